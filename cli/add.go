@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/electr1fy0/blue/storage"
+	"golang.org/x/term"
+)
+
+// inboxTitle is the note that "blue add --inbox" collects entries in.
+const inboxTitle = "Inbox"
+
+// Add captures standard input as a note. With a title it appends to the
+// note of that name, creating it if needed; with --inbox it appends a
+// timestamped entry to the inbox note.
+func Add(args []string) error {
+	fs := newFlags("add")
+	asJSON := fs.Bool("json", false, "print JSON")
+	title := fs.String("title", "", "note to append to or create")
+	tags := fs.String("tags", "", "comma-separated tags to add")
+	inbox := fs.Bool("inbox", false, "append to the "+inboxTitle+" note with a timestamp")
+	stamp := fs.Bool("stamp", false, "start the entry with a timestamp")
+	newNote := fs.Bool("new", false, "always create a new note")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() > 0 || (*inbox && *title != "") {
+		return fmt.Errorf("usage: blue add [--json] [--title t | --inbox] [--tags a,b] [--stamp] [--new]")
+	}
+
+	text, err := readCapture()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("nothing to add")
+	}
+	if *inbox {
+		*title = inboxTitle
+		*stamp = true
+	}
+	if *stamp {
+		text = "### " + time.Now().Format("2006-01-02 15:04") + "\n\n" + text
+	}
+
+	vault, nb, err := openVault(storage.OpenExclusive)
+	if err != nil {
+		return err
+	}
+
+	var n *storage.Note
+	if *title != "" && !*newNote {
+		if n, err = noteByTitle(nb, *title); err != nil {
+			vault.Close()
+			return err
+		}
+	}
+	added := parseTags(*tags)
+	if n != nil {
+		meta, body := storage.ParseFrontMatter(n.Content)
+		for _, t := range added {
+			if !hasTag(meta.Tags, t) {
+				meta.Tags = append(meta.Tags, t)
+			}
+		}
+		n.SetContent(storage.BuildContentWithMeta(meta, appendEntry(body, text)))
+	} else {
+		body := text
+		if *title != "" {
+			body = "# " + *title + "\n\n" + text
+		}
+		n = &storage.Note{Content: storage.BuildContentWithMeta(storage.Meta{Tags: added}, body)}
+		n.Title = storage.ExtractTitle(n.Content)
+		nb.AddNote(n)
+	}
+	if err := saveVault(vault, nb); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(toJSON(n, false))
+	}
+	fmt.Println(n.ID)
+	return nil
+}
+
+// readCapture reads all of standard input. At a terminal it says how to
+// finish, since nothing else would.
+func readCapture() (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "Type the note, then press ctrl+d on an empty line.")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	return string(data), err
+}
+
+// noteByTitle returns the note titled title (ignoring case), or nil if there
+// is none.
+func noteByTitle(nb *storage.Notebook, title string) (*storage.Note, error) {
+	var found *storage.Note
+	for _, n := range nb.Notes {
+		if !strings.EqualFold(n.Title, title) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several notes are titled %q; use --new or rename one", title)
+		}
+		found = n
+	}
+	return found, nil
+}
+
+// appendEntry adds text to the end of body as a new paragraph.
+func appendEntry(body, text string) string {
+	body = strings.TrimRight(body, "\n")
+	text = strings.TrimRight(text, "\n") + "\n"
+	if body == "" {
+		return text
+	}
+	return body + "\n\n" + text
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/electr1fy0/blue/storage"
+)
+
+// Backup lists or restores vault backups.
+func Backup(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: blue backup list | blue backup restore <n>")
+	}
+	backups, err := storage.ListBackups()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		if len(backups) == 0 {
+			fmt.Println("No backups.")
+			return nil
+		}
+		for i, b := range backups {
+			fmt.Printf("%2d  %s  %8d bytes  %s\n", i+1, b.Time.Format("2006-01-02 15:04:05"), b.Size, filepath.Base(b.Path))
+		}
+		return nil
+	case "restore":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: blue backup restore <n>")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(backups) {
+			return fmt.Errorf("no backup %q; see blue backup list", args[1])
+		}
+		b := backups[n-1]
+		pw, err := promptSecret("Password for backup: ")
+		if err != nil {
+			return err
+		}
+		if err := storage.RestoreBackup(b.Path, pw); err != nil {
+			return err
+		}
+		fmt.Printf("Restored backup from %s.\n", b.Time.Format("2006-01-02 15:04:05"))
+		return nil
+	default:
+		return fmt.Errorf("unknown backup command %q", args[0])
+	}
+}
@@ -0,0 +1,135 @@
+// Package cli implements blue's non-interactive subcommands.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/electr1fy0/blue/config"
+	"github.com/electr1fy0/blue/storage"
+	"golang.org/x/term"
+)
+
+// cfg is the configuration the command runs with.
+var cfg = config.Default()
+
+// defaultLockTimeout is how long commands wait for a vault another session
+// has open when lock_timeout isn't set. Unlike the notebook UI they can't
+// ask whether to open it read-only instead.
+const defaultLockTimeout = 10 * time.Second
+
+// Run dispatches args (without the program name) to a subcommand.
+func Run(args []string, c config.Config) error {
+	cfg = c
+	if cfg.LockTimeout == 0 {
+		storage.LockTimeout = defaultLockTimeout
+	}
+	switch args[0] {
+	case "config":
+		return Config(args[1:])
+	case "ls":
+		return List(args[1:])
+	case "cat":
+		return Cat(args[1:])
+	case "new":
+		return New(args[1:])
+	case "add":
+		return Add(args[1:])
+	case "edit":
+		return Edit(args[1:])
+	case "rm":
+		return Remove(args[1:])
+	case "search":
+		return Search(args[1:])
+	case "tag":
+		return Tag(args[1:])
+	case "export":
+		return Export(args[1:])
+	case "recover":
+		return Recover(args[1:])
+	case "backup":
+		return Backup(args[1:])
+	case "vault":
+		return Vault(args[1:])
+	case "help":
+		Usage()
+		return nil
+	default:
+		Usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func Usage() {
+	fmt.Fprintln(os.Stderr, `usage: blue [--vault name] [command]
+
+Run without a command to start the notebook UI.
+
+options:
+  --vault name          vault to use (default $BLUE_VAULT, then "default")
+  --config file         config file (default $XDG_CONFIG_HOME/blue/config.toml)
+  --set key=value       override a config setting; may be repeated
+
+commands:
+  ls [--archived] [--trash] [--tag t]
+                              list notes, pinned and newest first
+  cat <note>                  print a note
+  new [--title t] [--tags a,b]
+                              create a note from stdin, or in $EDITOR
+  add [--title t | --inbox] [--tags a,b]
+                              append stdin to the note titled t (created if
+                              missing), or to the Inbox note with a timestamp
+  edit <note>                 replace a note with stdin, or edit it in $EDITOR
+  rm <note>...                move notes to the trash
+  search <term>               list notes whose title or text contains term
+  tag <note> [+tag|-tag]...   show, add or remove tags
+  export [dir]                write every note to dir as Markdown
+  config [path]               show the settings in effect, or the config file path
+  recover                     unlock the vault with a recovery key and set a new password
+  backup list                 list vault backups, newest first
+  backup restore <n>          replace the vault with backup number n from the list
+  vault list                  list vaults
+  vault create <name>         create a vault with its own password
+  vault rename <old> <new>    rename a vault
+  vault delete <name>         delete a vault and its backups
+  vault sync <name> [url|off|default]
+                              show or set the vault's sync server
+  vault sync-token <name> [token|-|default]
+                              show whether the vault has its own sync server
+                              token, or set it ("-" reads it from stdin)
+  vault sync-key <name> [key]
+                              show the key notes are encrypted with for the
+                              sync server, or set the one from another device
+
+A <note> is an ID, an ID prefix of at least four characters, or a title.
+ls, cat, new, add, search, tag and export take --json for machine-readable output.
+
+Commands that change a vault open in another session, such as the notebook UI,
+wait for it to close for lock_timeout (10s unless set), then fail.
+
+The password is read from $BLUE_PASSWORD, else from the first line printed by
+$BLUE_PASSWORD_COMMAND (run with sh -c), else asked for on the terminal.`)
+}
+
+// promptSecret reads a line without echo. It asks on the controlling
+// terminal when stdin is piped, so input can come from a pipe.
+func promptSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return "", fmt.Errorf("no terminal to ask for a password; set %s or %s", passwordEnv, passwordCommandEnv)
+		}
+		defer tty.Close()
+		fd = int(tty.Fd())
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
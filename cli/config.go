@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/electr1fy0/blue/config"
+)
+
+// Config prints the settings in effect, in config file syntax.
+func Config(args []string) error {
+	if len(args) == 1 && args[0] == "path" {
+		path, err := config.Path()
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("usage: blue config [path]")
+	}
+
+	if cfg.File != "" {
+		fmt.Printf("# %s\n", cfg.File)
+	} else if path, err := config.Path(); err == nil {
+		fmt.Printf("# %s (not created; showing defaults)\n", path)
+	}
+	for _, key := range config.Keys() {
+		v, _ := cfg.Get(key)
+		if config.Secret(key) && v != "" {
+			v = "(set)"
+		}
+		if _, err := strconv.ParseBool(v); err != nil {
+			v = strconv.Quote(v)
+		}
+		fmt.Printf("\n# %s ($%s)\n%s = %s\n", config.Doc(key), config.EnvVar(key), key, v)
+	}
+	if len(cfg.Keys) > 0 {
+		fmt.Println()
+		actions := slices.Sorted(maps.Keys(cfg.Keys))
+		for _, a := range actions {
+			fmt.Printf("key.%s = %s\n", a, strconv.Quote(cfg.Keys[a]))
+		}
+	}
+	return nil
+}
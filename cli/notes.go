@@ -0,0 +1,548 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/electr1fy0/blue/storage"
+	"github.com/electr1fy0/blue/utils"
+)
+
+// noteJSON is how a note is printed with --json.
+type noteJSON struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Tags      []string  `json:"tags"`
+	Pinned    bool      `json:"pinned"`
+	Favorite  bool      `json:"favorite"`
+	Archived  bool      `json:"archived"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+	Content   string    `json:"content,omitempty"`
+}
+
+func toJSON(n *storage.Note, withContent bool) noteJSON {
+	meta, _ := storage.ParseFrontMatter(n.Content)
+	j := noteJSON{
+		ID:        n.ID,
+		Title:     n.Title,
+		Tags:      meta.Tags,
+		Pinned:    meta.Pinned,
+		Favorite:  meta.Favorite,
+		Archived:  meta.Archived,
+		CreatedAt: n.CreatedAt,
+		UpdatedAt: n.UpdatedAt,
+		DeletedAt: n.DeletedAt,
+	}
+	if j.Tags == nil {
+		j.Tags = []string{}
+	}
+	if withContent {
+		j.Content = n.Content
+	}
+	return j
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// newFlags returns a flag set for a note command that reports errors
+// instead of exiting.
+func newFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("blue "+name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseFlags parses args with fs, allowing flags after the positional
+// arguments too, as in "blue search todo --json".
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			// everything after "--" is positional
+			pos = append(pos, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		pos = append(pos, rest[0])
+		args = rest[1:]
+	}
+	return fs.Parse(append([]string{"--"}, pos...))
+}
+
+// splitFlags separates the flags fs defines from the other arguments, so
+// that those may start with a dash, as "-tag" does for blue tag. Only
+// boolean flags are supported, as a flag's value would count as positional.
+func splitFlags(fs *flag.FlagSet, args []string) (flags, pos []string) {
+	for i, a := range args {
+		if a == "--" {
+			return flags, append(pos, args[i+1:]...)
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if strings.HasPrefix(a, "-") && fs.Lookup(name) != nil {
+			flags = append(flags, a)
+		} else {
+			pos = append(pos, a)
+		}
+	}
+	return flags, pos
+}
+
+// printNotes lists notes one per line, or as a JSON array.
+func printNotes(notes []*storage.Note, asJSON bool) error {
+	if asJSON {
+		out := make([]noteJSON, len(notes))
+		for i, n := range notes {
+			out[i] = toJSON(n, false)
+		}
+		return printJSON(out)
+	}
+	for _, n := range notes {
+		meta, _ := storage.ParseFrontMatter(n.Content)
+		line := fmt.Sprintf("%-8s  %s  %s", storage.ShortID(n.ID), n.UpdatedAt.Format("2006-01-02 15:04"), n.Title)
+		if len(meta.Tags) > 0 {
+			line += "  [" + strings.Join(meta.Tags, ",") + "]"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// sortNotes orders notes as the notebook UI does by default: pinned, then
+// favorites, then most recently updated.
+func sortNotes(notes []*storage.Note) {
+	metas := make(map[*storage.Note]storage.Meta, len(notes))
+	for _, n := range notes {
+		metas[n], _ = storage.ParseFrontMatter(n.Content)
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		mi, mj := metas[notes[i]], metas[notes[j]]
+		if mi.Pinned != mj.Pinned {
+			return mi.Pinned
+		}
+		if mi.Favorite != mj.Favorite {
+			return mi.Favorite
+		}
+		return notes[i].UpdatedAt.After(notes[j].UpdatedAt)
+	})
+}
+
+// findNote resolves ref as a note ID, an ID prefix of at least four
+// characters, or a title (ignoring case).
+func findNote(nb *storage.Notebook, ref string) (*storage.Note, error) {
+	if n, ok := nb.GetNote(ref); ok {
+		return n, nil
+	}
+	var matches []*storage.Note
+	if len(ref) >= 4 {
+		for id, n := range nb.Notes {
+			if strings.HasPrefix(id, ref) {
+				matches = append(matches, n)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		for _, n := range nb.Notes {
+			if strings.EqualFold(n.Title, ref) {
+				matches = append(matches, n)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no note %q", ref)
+	case 1:
+		return matches[0], nil
+	}
+	sortNotes(matches)
+	var s strings.Builder
+	fmt.Fprintf(&s, "%q matches %d notes; use an ID:", ref, len(matches))
+	for _, n := range matches {
+		fmt.Fprintf(&s, "\n  %s  %s", storage.ShortID(n.ID), n.Title)
+	}
+	return nil, fmt.Errorf("%s", s.String())
+}
+
+// saveVault writes nb and closes the vault.
+func saveVault(vault storage.Backend, nb *storage.Notebook) error {
+	defer vault.Close()
+	return vault.Save(nb)
+}
+
+// parseTags splits a comma-separated tag list.
+func parseTags(s string) []string {
+	var tags []string
+	for t := range strings.SplitSeq(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// List prints the notes in the vault.
+func List(args []string) error {
+	fs := newFlags("ls")
+	asJSON := fs.Bool("json", false, "print JSON")
+	archived := fs.Bool("archived", false, "include archived notes")
+	trash := fs.Bool("trash", false, "list the trash instead")
+	tag := fs.String("tag", "", "only notes with this tag")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() > 0 {
+		return fmt.Errorf("usage: blue ls [--json] [--archived] [--trash] [--tag t]")
+	}
+
+	vault, nb, err := openVault(storage.OpenReadOnly)
+	if err != nil {
+		return err
+	}
+	defer vault.Close()
+
+	src := nb.Notes
+	if *trash {
+		src = nb.Trash
+	}
+	var notes []*storage.Note
+	for _, n := range src {
+		meta, _ := storage.ParseFrontMatter(n.Content)
+		if meta.Archived && !*archived && !*trash {
+			continue
+		}
+		if *tag != "" && !hasTag(meta.Tags, *tag) {
+			continue
+		}
+		notes = append(notes, n)
+	}
+	sortNotes(notes)
+	return printNotes(notes, *asJSON)
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cat prints a note's content.
+func Cat(args []string) error {
+	fs := newFlags("cat")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() != 1 {
+		return fmt.Errorf("usage: blue cat [--json] <note>")
+	}
+
+	vault, nb, err := openVault(storage.OpenReadOnly)
+	if err != nil {
+		return err
+	}
+	defer vault.Close()
+
+	n, err := findNote(nb, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(toJSON(n, true))
+	}
+	fmt.Print(n.Content)
+	if !strings.HasSuffix(n.Content, "\n") {
+		fmt.Println()
+	}
+	return nil
+}
+
+// New creates a note from standard input, or in the editor when run in a
+// terminal, and prints its ID.
+func New(args []string) error {
+	fs := newFlags("new")
+	asJSON := fs.Bool("json", false, "print JSON")
+	title := fs.String("title", "", "note title (default: first heading or line)")
+	tags := fs.String("tags", "", "comma-separated tags")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() > 0 {
+		return fmt.Errorf("usage: blue new [--json] [--title t] [--tags a,b]")
+	}
+
+	content, piped, err := readInput()
+	if err != nil {
+		return err
+	}
+	if !piped {
+		content, err = utils.OpenEditorWithContent(storage.BuildContentWithMeta(storage.Meta{}, "# New note\n\nStart writing here...\n"))
+		if err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("empty note; nothing saved")
+	}
+	if *title != "" && storage.ExtractTitle(content) != *title {
+		// in the text, so that editing the note keeps it
+		content = setTitle(content, *title)
+	}
+	if *tags != "" {
+		meta, body := storage.ParseFrontMatter(content)
+		meta.Tags = parseTags(*tags)
+		content = storage.BuildContentWithMeta(meta, body)
+	}
+
+	vault, nb, err := openVault(storage.OpenExclusive)
+	if err != nil {
+		return err
+	}
+	n := &storage.Note{Title: storage.ExtractTitle(content), Content: content}
+	nb.AddNote(n)
+	if err := saveVault(vault, nb); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(toJSON(n, false))
+	}
+	fmt.Println(n.ID)
+	return nil
+}
+
+// setTitle makes title the note's first heading, replacing the heading the
+// note starts with, if any.
+func setTitle(content, title string) string {
+	meta, body := storage.ParseFrontMatter(content)
+	hasMeta := body != content
+	lines := strings.Split(body, "\n")
+	i := slices.IndexFunc(lines, func(l string) bool { return strings.TrimSpace(l) != "" })
+	if i >= 0 && strings.HasPrefix(strings.TrimSpace(lines[i]), "# ") {
+		lines[i] = "# " + title
+		body = strings.Join(lines, "\n")
+	} else {
+		body = "# " + title + "\n\n" + body
+	}
+	if !hasMeta {
+		return body
+	}
+	return storage.BuildContentWithMeta(meta, body)
+}
+
+// Edit replaces a note's content with standard input, or opens it in the
+// editor when run in a terminal.
+func Edit(args []string) error {
+	fs := newFlags("edit")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() != 1 {
+		return fmt.Errorf("usage: blue edit <note>")
+	}
+	input, piped, err := readInput()
+	if err != nil {
+		return err
+	}
+
+	vault, nb, err := openVault(storage.OpenExclusive)
+	if err != nil {
+		return err
+	}
+	n, err := findNote(nb, fs.Arg(0))
+	if err != nil {
+		vault.Close()
+		return err
+	}
+	content := input
+	if piped {
+		// keep the note's tags and flags unless new ones were given
+		if _, body := storage.ParseFrontMatter(input); body == input {
+			meta, _ := storage.ParseFrontMatter(n.Content)
+			content = storage.BuildContentWithMeta(meta, input)
+		}
+	} else {
+		if content, err = utils.OpenEditorWithContent(n.Content); err != nil {
+			vault.Close()
+			return err
+		}
+	}
+	if content == n.Content {
+		vault.Close()
+		fmt.Println("No changes.")
+		return nil
+	}
+	n.SetContent(content)
+	n.Title = storage.ExtractTitle(content)
+	if err := saveVault(vault, nb); err != nil {
+		return err
+	}
+	fmt.Printf("Updated %s.\n", n.Title)
+	return nil
+}
+
+// Remove moves notes to the trash.
+func Remove(args []string) error {
+	fs := newFlags("rm")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() == 0 {
+		return fmt.Errorf("usage: blue rm <note>...")
+	}
+
+	vault, nb, err := openVault(storage.OpenExclusive)
+	if err != nil {
+		return err
+	}
+	var titles []string
+	for _, ref := range fs.Args() {
+		n, err := findNote(nb, ref)
+		if err != nil {
+			vault.Close()
+			return err
+		}
+		nb.DeleteNote(n.ID)
+		titles = append(titles, n.Title)
+	}
+	if err := saveVault(vault, nb); err != nil {
+		return err
+	}
+	for _, t := range titles {
+		fmt.Printf("Moved to trash: %s\n", t)
+	}
+	return nil
+}
+
+// Search lists notes whose title or body contains the search term.
+func Search(args []string) error {
+	fs := newFlags("search")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() == 0 {
+		return fmt.Errorf("usage: blue search [--json] <term>")
+	}
+	term := strings.Join(fs.Args(), " ")
+
+	vault, nb, err := openVault(storage.OpenReadOnly)
+	if err != nil {
+		return err
+	}
+	defer vault.Close()
+
+	var notes []*storage.Note
+	for _, n := range nb.Notes {
+		if n.Matches(term) {
+			notes = append(notes, n)
+		}
+	}
+	sortNotes(notes)
+	return printNotes(notes, *asJSON)
+}
+
+// Tag shows or changes a note's tags: "+t" or "t" adds a tag, "-t" removes
+// one.
+func Tag(args []string) error {
+	fs := newFlags("tag")
+	asJSON := fs.Bool("json", false, "print JSON")
+	flags, pos := splitFlags(fs, args)
+	if err := fs.Parse(flags); err != nil || len(pos) == 0 {
+		return fmt.Errorf("usage: blue tag [--json] <note> [+tag|-tag]...")
+	}
+	ops := pos[1:]
+
+	mode := storage.OpenReadOnly
+	if len(ops) > 0 {
+		mode = storage.OpenExclusive
+	}
+	vault, nb, err := openVault(mode)
+	if err != nil {
+		return err
+	}
+	n, err := findNote(nb, pos[0])
+	if err != nil {
+		vault.Close()
+		return err
+	}
+
+	meta, body := storage.ParseFrontMatter(n.Content)
+	if len(ops) == 0 {
+		vault.Close()
+	} else {
+		for _, op := range ops {
+			if t, ok := strings.CutPrefix(op, "-"); ok {
+				meta.Tags = removeTag(meta.Tags, t)
+			} else if t := strings.TrimPrefix(op, "+"); t != "" && !hasTag(meta.Tags, t) {
+				meta.Tags = append(meta.Tags, t)
+			}
+		}
+		n.Content = storage.BuildContentWithMeta(meta, body)
+		n.UpdatedAt = time.Now()
+		if err := saveVault(vault, nb); err != nil {
+			return err
+		}
+	}
+
+	if *asJSON {
+		if meta.Tags == nil {
+			meta.Tags = []string{}
+		}
+		return printJSON(meta.Tags)
+	}
+	fmt.Println(strings.Join(meta.Tags, ","))
+	return nil
+}
+
+func removeTag(tags []string, tag string) []string {
+	out := tags[:0]
+	for _, t := range tags {
+		if !strings.EqualFold(t, tag) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Export writes every note to a directory as Markdown, or prints them all
+// as JSON.
+func Export(args []string) error {
+	fs := newFlags("export")
+	asJSON := fs.Bool("json", false, "print all notes as JSON instead of writing files")
+	if err := parseFlags(fs, args); err != nil || fs.NArg() > 1 {
+		return fmt.Errorf("usage: blue export [--json] [dir]")
+	}
+
+	vault, nb, err := openVault(storage.OpenReadOnly)
+	if err != nil {
+		return err
+	}
+	defer vault.Close()
+
+	if *asJSON {
+		notes := make([]*storage.Note, 0, len(nb.Notes))
+		for _, n := range nb.Notes {
+			notes = append(notes, n)
+		}
+		sortNotes(notes)
+		out := make([]noteJSON, len(notes))
+		for i, n := range notes {
+			out[i] = toJSON(n, true)
+		}
+		return printJSON(out)
+	}
+
+	dir := fs.Arg(0)
+	if dir == "" {
+		dir = filepath.Join(cfg.ExportDir, fmt.Sprintf("blue_export_%d", time.Now().Unix()))
+	}
+	count, err := storage.ExportNotes(nb, dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d notes to %s/\n", count, dir)
+	return nil
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/electr1fy0/blue/storage"
+	"golang.org/x/term"
+)
+
+// Password sources for commands that open a vault, tried in this order
+// before prompting.
+const (
+	passwordEnv        = "BLUE_PASSWORD"
+	passwordCommandEnv = "BLUE_PASSWORD_COMMAND"
+)
+
+// vaultPassword returns $BLUE_PASSWORD, else the first line printed by
+// $BLUE_PASSWORD_COMMAND, else asks on the terminal.
+func vaultPassword() (string, error) {
+	if pw := os.Getenv(passwordEnv); pw != "" {
+		return pw, nil
+	}
+	if cmd := os.Getenv(passwordCommandEnv); cmd != "" {
+		return runPasswordCommand(cmd)
+	}
+	return promptSecret("Password: ")
+}
+
+// runPasswordCommand runs cmd with the shell, e.g. "pass show blue".
+func runPasswordCommand(cmd string) (string, error) {
+	c := exec.Command("sh", "-c", cmd)
+	c.Stderr = os.Stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("password command: %w", err)
+	}
+	line, _, _ := bytes.Cut(out, []byte("\n"))
+	pw := strings.TrimRight(string(line), "\r")
+	if pw == "" {
+		return "", fmt.Errorf("password command printed nothing")
+	}
+	return pw, nil
+}
+
+// openVault unlocks the active vault for a command. Commands that only read
+// pass storage.OpenReadOnly, so they work while the notebook UI is open.
+func openVault(mode storage.OpenMode) (storage.Backend, *storage.Notebook, error) {
+	exists, err := storage.NotebookExists()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, fmt.Errorf("vault %q does not exist", storage.CurrentVault())
+	}
+	pw, err := vaultPassword()
+	if err != nil {
+		return nil, nil, err
+	}
+	vault, nb, err := storage.OpenVault(pw, mode)
+	var locked *storage.LockedError
+	if errors.As(err, &locked) {
+		return nil, nil, fmt.Errorf("%w; waited %s (lock_timeout)", err, storage.LockTimeout)
+	}
+	return vault, nb, err
+}
+
+// readInput returns the piped standard input, or ok=false when stdin is a
+// terminal.
+func readInput() (s string, ok bool, err error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", false, nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
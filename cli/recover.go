@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/electr1fy0/blue/storage"
+)
+
+// Recover resets the vault password using a recovery key.
+func Recover(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("recover takes no arguments")
+	}
+	exists, err := storage.NotebookExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("no notebook found")
+	}
+
+	key, err := promptSecret("Recovery key: ")
+	if err != nil {
+		return err
+	}
+	pw, err := promptSecret("New password: ")
+	if err != nil {
+		return err
+	}
+	if pw == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+	confirm, err := promptSecret("Confirm new password: ")
+	if err != nil {
+		return err
+	}
+	if pw != confirm {
+		return fmt.Errorf("passwords do not match")
+	}
+
+	vault, nb, err := storage.RecoverVault(key, pw)
+	if err != nil {
+		return err
+	}
+	defer vault.Close()
+
+	fmt.Printf("Password reset. Notebook has %d notes.\n", len(nb.Notes))
+	return nil
+}
@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/electr1fy0/blue/storage"
+)
+
+// Vault manages named vaults.
+func Vault(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: blue vault list|create|rename|delete|sync|sync-token|sync-key")
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		names, err := storage.ListVaults()
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			marker := " "
+			if n == storage.CurrentVault() {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, n)
+		}
+		return nil
+	case "create":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: blue vault create <name>")
+		}
+		return createVault(args[1])
+	case "rename":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: blue vault rename <old> <new>")
+		}
+		if err := storage.RenameVault(args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("Renamed vault %s to %s.\n", args[1], args[2])
+		return nil
+	case "delete", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: blue vault delete <name>")
+		}
+		return deleteVault(args[1])
+	case "sync":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: blue vault sync <name> [url|off|default]")
+		}
+		return vaultSync(args[1], args[2:])
+	case "sync-token":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: blue vault sync-token <name> [token|-|default]")
+		}
+		return vaultSyncToken(args[1], args[2:])
+	case "sync-key":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: blue vault sync-key <name> [key]")
+		}
+		return vaultSyncKey(args[1], args[2:])
+	default:
+		return fmt.Errorf("unknown vault command %q", args[0])
+	}
+}
+
+func createVault(name string) error {
+	if err := storage.SetVault(name); err != nil {
+		return err
+	}
+	exists, err := storage.VaultExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("vault %q already exists", name)
+	}
+
+	pw, err := promptSecret("Password for " + name + ": ")
+	if err != nil {
+		return err
+	}
+	if pw == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+	confirm, err := promptSecret("Confirm password: ")
+	if err != nil {
+		return err
+	}
+	if pw != confirm {
+		return fmt.Errorf("passwords do not match")
+	}
+
+	vault, _, err := storage.CreateVault(pw)
+	if err != nil {
+		return err
+	}
+	defer vault.Close()
+	fmt.Printf("Created vault %s.\n", name)
+	return nil
+}
+
+func deleteVault(name string) error {
+	if exists, err := storage.VaultExists(name); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+
+	fmt.Fprintf(os.Stderr, "This permanently deletes vault %s and all its backups.\nType the vault name to confirm: ", name)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != name {
+		return fmt.Errorf("not deleted")
+	}
+	if err := storage.DeleteVault(name); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted vault %s.\n", name)
+	return nil
+}
+
+func vaultSync(name string, args []string) error {
+	if exists, err := storage.VaultExists(name); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+	vs, err := storage.LoadVaultSettings(name)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		switch {
+		case vs.SyncDisabled:
+			fmt.Println("off")
+		case vs.SyncURL == "":
+			fmt.Printf("%s (default)\n", cfg.SyncURL)
+		default:
+			fmt.Println(vs.SyncURL)
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "off":
+		vs.SyncDisabled = true
+	case "default":
+		vs = storage.VaultSettings{}
+	default:
+		if !strings.HasPrefix(args[0], "ws://") && !strings.HasPrefix(args[0], "wss://") {
+			return fmt.Errorf("sync url must start with ws:// or wss://")
+		}
+		vs.SyncURL = args[0]
+		vs.SyncDisabled = false
+	}
+	return storage.SaveVaultSettings(name, vs)
+}
+
+// vaultSyncToken shows whether the vault has its own sync server token, or
+// sets it. "-" reads the token from stdin, keeping it out of the shell
+// history.
+func vaultSyncToken(name string, args []string) error {
+	if exists, err := storage.VaultExists(name); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+	vs, err := storage.LoadVaultSettings(name)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		switch {
+		case vs.SyncToken != "":
+			fmt.Println("set")
+		case cfg.SyncToken != "":
+			fmt.Println("set (default)")
+		default:
+			fmt.Println("none")
+		}
+		return nil
+	}
+
+	switch token := args[0]; token {
+	case "default":
+		vs.SyncToken = ""
+	case "-":
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		if vs.SyncToken = strings.TrimSpace(line); vs.SyncToken == "" {
+			return fmt.Errorf("no token on stdin")
+		}
+	default:
+		vs.SyncToken = token
+	}
+	return storage.SaveVaultSettings(name, vs)
+}
+
+// vaultSyncKey shows the key the vault's notes are encrypted with for the
+// sync server, or replaces it with the key of another device's copy.
+func vaultSyncKey(name string, args []string) error {
+	if err := storage.SetVault(name); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		vault, nb, err := openVault(storage.OpenReadOnly)
+		if err != nil {
+			return err
+		}
+		defer vault.Close()
+		if len(nb.SyncKey) == 0 {
+			fmt.Println("none yet; one is made the first time the vault syncs")
+			return nil
+		}
+		fmt.Println(storage.FormatSyncKey(nb.SyncKey))
+		return nil
+	}
+
+	key, err := storage.ParseSyncKey(args[0])
+	if err != nil {
+		return err
+	}
+	vault, nb, err := openVault(storage.OpenExclusive)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(nb.SyncKey, key) {
+		vault.Close()
+		fmt.Printf("Vault %s already has this sync key.\n", name)
+		return nil
+	}
+	nb.SyncKey = key
+	// what the server has was sealed with the old key; start over, so
+	// every note is read again and sent under the new one
+	nb.Sync = nil
+	if err := saveVault(vault, nb); err != nil {
+		return err
+	}
+	fmt.Printf("Set the sync key of vault %s; it syncs every note again next time.\n", name)
+	return nil
+}
@@ -0,0 +1,428 @@
+// Package config loads blue's settings from
+// $XDG_CONFIG_HOME/blue/config.toml (or config.yaml), with environment
+// variable and command line overrides.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/electr1fy0/blue/server"
+)
+
+// Config holds every setting. The zero value is not useful; start from
+// Default.
+type Config struct {
+	SyncURL       string                       // sync server for vaults that don't set one
+	SyncToken     string                       // token for that server, for vaults that don't set one
+	Editor        string                       // command used to edit notes; may include arguments
+	EditorMode    string                       // builtin, or external to use Editor
+	RequireRAM    bool                         // only write notes for the external editor or glow to RAM-backed storage
+	Renderer      string                       // auto, glow, glamour or plain
+	GlowStyle     string                       // style passed to glow -s
+	Sort          string                       // initial list order: date or title
+	Autosave      bool                         // save after changes; otherwise only when leaving the vault
+	AutosaveDelay time.Duration                // wait this long after a change before saving
+	LockTimeout   time.Duration                // how long to wait for a vault another session holds
+	AutoLock      time.Duration                // lock the vault after this long without input; 0 never
+	MaxBackups    int                          // backups kept of each vault; 0 none
+	MaxRevisions  int                          // earlier versions kept of each note; 0 none
+	TrashDays     int                          // days deleted notes stay in the trash; 0 forever
+	ExportDir     string                       // where exports go; "" for a new directory under the working directory
+	Keymap        string                       // key binding preset: default, vim or emacs
+	Keys          map[string]string            // action → comma-separated keys, from key.<action> settings
+	Theme         string                       // color theme
+	Themes        map[string]map[string]string // user themes: name → field → value, from theme.<name>.<field>
+
+	File string // the file the settings were read from; "" if there was none
+}
+
+// Default returns the settings used when nothing is configured.
+func Default() Config {
+	return Config{
+		SyncURL:      server.DefaultURL,
+		EditorMode:   "builtin",
+		Renderer:     "auto",
+		Sort:         "date",
+		Autosave:     true,
+		MaxBackups:   5,
+		MaxRevisions: 50,
+		TrashDays:    30,
+		Keymap:       "default",
+		Theme:        "dark",
+	}
+}
+
+// setting describes one key of the config file.
+type setting struct {
+	key string
+	doc string
+	set func(c *Config, v string) error
+	get func(c Config) string
+}
+
+var settings = []setting{
+	{"sync_url", "sync server for vaults without their own", func(c *Config, v string) error {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			return fmt.Errorf("%q is not a ws:// or wss:// URL", v)
+		}
+		c.SyncURL = v
+		return nil
+	}, func(c Config) string { return c.SyncURL }},
+	{"sync_token", "token or shared secret the sync server expects", func(c *Config, v string) error {
+		c.SyncToken = strings.TrimSpace(v)
+		return nil
+	}, func(c Config) string { return c.SyncToken }},
+	{"editor", "external editor command (default $EDITOR, then nvim, vi)", func(c *Config, v string) error {
+		c.Editor = strings.TrimSpace(v)
+		return nil
+	}, func(c Config) string { return c.Editor }},
+	{"editor_mode", "edit notes in the builtin editor or the external one", func(c *Config, v string) error {
+		return oneOf(&c.EditorMode, v, "builtin", "external")
+	}, func(c Config) string { return c.EditorMode }},
+	{"require_ram_temp", "refuse to write notes to temp files unless RAM-backed storage exists", func(c *Config, v string) error {
+		return boolean(&c.RequireRAM, v)
+	}, func(c Config) string { return strconv.FormatBool(c.RequireRAM) }},
+	{"renderer", "auto, glow, glamour or plain", func(c *Config, v string) error {
+		return oneOf(&c.Renderer, v, "auto", "glow", "glamour", "plain")
+	}, func(c Config) string { return c.Renderer }},
+	{"glow_style", "glow style name or path to a style file (default: the theme's)", func(c *Config, v string) error {
+		c.GlowStyle = v
+		return nil
+	}, func(c Config) string { return c.GlowStyle }},
+	{"sort", "initial note order: date or title", func(c *Config, v string) error {
+		return oneOf(&c.Sort, v, "date", "title")
+	}, func(c Config) string { return c.Sort }},
+	{"autosave", "save in the background after each change", func(c *Config, v string) error {
+		return boolean(&c.Autosave, v)
+	}, func(c Config) string { return strconv.FormatBool(c.Autosave) }},
+	{"autosave_delay", "wait after a change before saving, e.g. 2s", func(c *Config, v string) error {
+		return duration(&c.AutosaveDelay, v)
+	}, func(c Config) string { return c.AutosaveDelay.String() }},
+	{"lock_timeout", "wait for a vault open in another session, e.g. 10s; 0 asks in the notebook UI and waits 10s in commands", func(c *Config, v string) error {
+		return duration(&c.LockTimeout, v)
+	}, func(c Config) string { return c.LockTimeout.String() }},
+	{"auto_lock", "lock the vault after this long without input, e.g. 10m; 0 never", func(c *Config, v string) error {
+		return duration(&c.AutoLock, v)
+	}, func(c Config) string { return c.AutoLock.String() }},
+	{"max_backups", "backups kept of each vault, made when it is first saved in a session; 0 none", func(c *Config, v string) error {
+		return count(&c.MaxBackups, v)
+	}, func(c Config) string { return strconv.Itoa(c.MaxBackups) }},
+	{"max_revisions", "earlier versions kept in each note's history; 0 none", func(c *Config, v string) error {
+		return count(&c.MaxRevisions, v)
+	}, func(c Config) string { return strconv.Itoa(c.MaxRevisions) }},
+	{"trash_days", "days deleted notes stay in the trash before being purged; 0 forever", func(c *Config, v string) error {
+		return count(&c.TrashDays, v)
+	}, func(c Config) string { return strconv.Itoa(c.TrashDays) }},
+	{"export_dir", "directory exports are written under", func(c *Config, v string) error {
+		dir, err := expandHome(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		c.ExportDir = dir
+		return nil
+	}, func(c Config) string { return c.ExportDir }},
+	{"keymap", "key binding preset: default, vim or emacs", func(c *Config, v string) error {
+		return oneOf(&c.Keymap, v, "default", "vim", "emacs")
+	}, func(c Config) string { return c.Keymap }},
+	{"theme", "dark, light, high-contrast, solarized or a theme.<name> defined here", func(c *Config, v string) error {
+		if v == "" {
+			return errors.New("must not be empty")
+		}
+		c.Theme = v
+		return nil
+	}, func(c Config) string { return c.Theme }},
+}
+
+// themePrefix starts the settings of a user-defined theme, e.g.
+// theme.mine.title = "#ff8800". The UI checks the field names.
+const themePrefix = "theme."
+
+// keyPrefix starts the settings that rebind one action, e.g. key.add = "a,n".
+// The UI checks the action names.
+const keyPrefix = "key."
+
+func oneOf(dst *string, v string, allowed ...string) error {
+	for _, a := range allowed {
+		if v == a {
+			*dst = v
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", v, strings.Join(allowed, ", "))
+}
+
+func boolean(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", v)
+	}
+	*dst = b
+	return nil
+}
+
+func count(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a whole number, 0 or more", v)
+	}
+	*dst = n
+	return nil
+}
+
+func duration(dst *time.Duration, v string) error {
+	if v == "0" {
+		*dst = 0
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fmt.Errorf("%q is not a duration like 500ms or 10s", v)
+	}
+	*dst = d
+	return nil
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+func lookup(key string) (setting, error) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, nil
+		}
+	}
+	return setting{}, fmt.Errorf("unknown setting %q", key)
+}
+
+// Set changes the setting called key, checking the value.
+func (c *Config) Set(key, value string) error {
+	if action, ok := strings.CutPrefix(key, keyPrefix); ok {
+		if action == "" {
+			return fmt.Errorf("%s: missing action name", key)
+		}
+		if c.Keys == nil {
+			c.Keys = make(map[string]string)
+		}
+		c.Keys[action] = value
+		return nil
+	}
+	if rest, ok := strings.CutPrefix(key, themePrefix); ok {
+		name, field, ok := strings.Cut(rest, ".")
+		if !ok || name == "" || field == "" {
+			return fmt.Errorf("%s: want theme.<name>.<field>", key)
+		}
+		if field == "glamour" {
+			var err error
+			if value, err = expandHome(value); err != nil {
+				return err
+			}
+		}
+		if c.Themes == nil {
+			c.Themes = make(map[string]map[string]string)
+		}
+		if c.Themes[name] == nil {
+			c.Themes[name] = make(map[string]string)
+		}
+		c.Themes[name][field] = value
+		return nil
+	}
+	s, err := lookup(key)
+	if err != nil {
+		return err
+	}
+	if err := s.set(c, value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// Keys lists the settings in the order they are documented.
+func Keys() []string {
+	keys := make([]string, len(settings))
+	for i, s := range settings {
+		keys[i] = s.key
+	}
+	return keys
+}
+
+// Get returns the value of the setting called key as it would be written in
+// the config file.
+func (c Config) Get(key string) (string, error) {
+	s, err := lookup(key)
+	if err != nil {
+		return "", err
+	}
+	return s.get(c), nil
+}
+
+// Doc describes the setting called key.
+func Doc(key string) string {
+	s, _ := lookup(key)
+	return s.doc
+}
+
+// Secret reports whether the setting called key holds a credential, which
+// shouldn't be printed.
+func Secret(key string) bool {
+	return key == "sync_token"
+}
+
+// EnvVar is the environment variable that overrides key, e.g. BLUE_SYNC_URL
+// or BLUE_KEY_ADD.
+func EnvVar(key string) string {
+	return "BLUE_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Dir is $XDG_CONFIG_HOME/blue, defaulting to ~/.config/blue.
+func Dir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(base, "blue"), nil
+}
+
+// Path returns the config file in Dir: config.toml, config.yaml or
+// config.yml, whichever exists first, else config.toml.
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range []string{"config.toml", "config.yaml", "config.yml"} {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return filepath.Join(dir, "config.toml"), nil
+}
+
+// Load reads the config file at path, or the default one if path is empty,
+// and then applies environment overrides. A missing default file is not an
+// error.
+func Load(path string) (Config, error) {
+	c := Default()
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = Path(); err != nil {
+			return c, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := c.parse(path, string(data)); err != nil {
+			return c, err
+		}
+		c.File = path
+	case !os.IsNotExist(err) || explicit:
+		return c, err
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(EnvVar(s.key)); ok {
+			if err := c.Set(s.key, v); err != nil {
+				return c, fmt.Errorf("$%s: %w", EnvVar(s.key), err)
+			}
+		}
+	}
+	// BLUE_KEY_ADD=n rebinds key.add
+	for _, kv := range os.Environ() {
+		name, v, _ := strings.Cut(kv, "=")
+		if action, ok := strings.CutPrefix(name, "BLUE_KEY_"); ok {
+			c.Set(keyPrefix+strings.ToLower(action), v)
+		}
+	}
+	return c, nil
+}
+
+// parse reads flat "key = value" (TOML) or "key: value" (YAML) lines.
+// Strings may be quoted; "#" starts a comment.
+func (c *Config) parse(path, data string) error {
+	sep := "="
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		sep = ":"
+	}
+	for i, line := range strings.Split(data, "\n") {
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", path, i+1, fmt.Sprintf(format, args...))
+		}
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return fail("sections are not supported; put %s at the top level", line)
+		}
+		key, val, ok := strings.Cut(line, sep)
+		if !ok {
+			return fail("expected key %s value", sep)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		v, err := unquote(val)
+		if err != nil {
+			return fail("%s: %v", key, err)
+		}
+		if err := c.Set(key, v); err != nil {
+			return fail("%v", err)
+		}
+	}
+	return nil
+}
+
+// stripComment drops a "#" comment that isn't inside quotes.
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", v)
+		}
+		return s, nil
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return "", fmt.Errorf("bad string %s", v)
+		}
+		return v[1 : len(v)-1], nil
+	}
+	return v, nil
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want func(Config) bool
+	}{
+		{"plain", "config.toml", "sort = title", func(c Config) bool { return c.Sort == "title" }},
+		{"double quotes", "config.toml", `editor = "code --wait"`, func(c Config) bool { return c.Editor == "code --wait" }},
+		{"single quotes", "config.toml", `glow_style = 'a "b"'`, func(c Config) bool { return c.GlowStyle == `a "b"` }},
+		{"escape", "config.toml", `glow_style = "a\tb"`, func(c Config) bool { return c.GlowStyle == "a\tb" }},
+		{"comment", "config.toml", "editor = vim # the best", func(c Config) bool { return c.Editor == "vim" }},
+		{"comment line", "config.toml", "# sort = title\n\nautosave = false", func(c Config) bool { return c.Sort == "date" && !c.Autosave }},
+		{"hash in double quotes", "config.toml", `glow_style = "a#b" # c`, func(c Config) bool { return c.GlowStyle == "a#b" }},
+		{"hash in single quotes", "config.toml", `glow_style = 'x # y'`, func(c Config) bool { return c.GlowStyle == "x # y" }},
+		{"duration", "config.toml", `autosave_delay = "500ms"`, func(c Config) bool { return c.AutosaveDelay == 500*time.Millisecond }},
+		{"zero duration", "config.toml", "auto_lock = 0", func(c Config) bool { return c.AutoLock == 0 }},
+		{"count", "config.toml", "max_backups = 0", func(c Config) bool { return c.MaxBackups == 0 }},
+		{"key binding", "config.toml", `key.add = "n,a"`, func(c Config) bool { return c.Keys["add"] == "n,a" }},
+		{"unbound key", "config.toml", `key.export = ""`, func(c Config) bool { v, ok := c.Keys["export"]; return ok && v == "" }},
+		{"theme", "config.toml", "theme.mine.accent = \"#cb4b16\"\ntheme = mine", func(c Config) bool {
+			return c.Theme == "mine" && c.Themes["mine"]["accent"] == "#cb4b16"
+		}},
+		{"yaml", "config.yaml", "---\nsort: title\nautosave: false", func(c Config) bool { return c.Sort == "title" && !c.Autosave }},
+		{"yaml colon in value", "config.yml", `sync_url: "wss://sync.example.com:8443/ws"`, func(c Config) bool {
+			return c.SyncURL == "wss://sync.example.com:8443/ws"
+		}},
+		{"yaml comment", "config.yaml", "editor: nvim  # or vi", func(c Config) bool { return c.Editor == "nvim" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			if err := c.parse(tt.file, tt.data); err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !tt.want(c) {
+				t.Errorf("unexpected config %+v", c)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string // in the error
+	}{
+		{"unknown key", "colour = red", `config.toml:1: unknown setting "colour"`},
+		{"bad value", "autosave = true\nsort = size", `config.toml:2: sort: "size" is not one of date, title`},
+		{"bad bool", "autosave = maybe", "is not true or false"},
+		{"bad duration", "auto_lock = soon", "is not a duration"},
+		{"negative duration", "auto_lock = -1s", "is not a duration"},
+		{"negative count", "max_backups = -1", "is not a whole number"},
+		{"bad url", "sync_url = http://example.com", "is not a ws:// or wss:// URL"},
+		{"section", "[ui]", "sections are not supported"},
+		{"no separator", "sort title", "expected key = value"},
+		{"unterminated double quote", `editor = "vim`, "bad string"},
+		{"unterminated single quote", `editor = 'vim`, "bad string"},
+		{"empty theme", `theme = ""`, "must not be empty"},
+		{"theme without field", "theme.mine = x", "want theme.<name>.<field>"},
+		{"key without action", "key. = a", "missing action name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			err := c.parse("config.toml", tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	tests := map[string]string{
+		"a = b":           "a = b",
+		"a = b # c":       "a = b ",
+		`a = "b # c"`:     `a = "b # c"`,
+		`a = 'b # c' # d`: `a = 'b # c' `,
+		`a = "it's" # d`:  `a = "it's" `,
+		"# all comment":   "",
+	}
+	for in, want := range tests {
+		if got := stripComment(in); got != want {
+			t.Errorf("stripComment(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	data := "sort = title\neditor = nano\nrenderer = plain\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BLUE_EDITOR", "vi")
+	t.Setenv("BLUE_RENDERER", "glamour")
+	t.Setenv("BLUE_KEY_ADD", "n")
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.File != path {
+		t.Errorf("File = %q, want %q", c.File, path)
+	}
+	if c.Sort != "title" {
+		t.Errorf("Sort = %q; the file should set it", c.Sort)
+	}
+	if c.Editor != "vi" || c.Renderer != "glamour" {
+		t.Errorf("Editor, Renderer = %q, %q; the environment should override the file", c.Editor, c.Renderer)
+	}
+	if c.Keys["add"] != "n" {
+		t.Errorf("key.add = %q, want n from $BLUE_KEY_ADD", c.Keys["add"])
+	}
+
+	// --set is applied last
+	if err := c.Set("renderer", "auto"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Renderer != "auto" {
+		t.Errorf("Renderer = %q after Set", c.Renderer)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(filepath.Join(dir, "missing.toml")); err == nil {
+		t.Error("a missing explicit file was accepted")
+	}
+
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("sort = title\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BLUE_SORT", "size")
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "$BLUE_SORT") {
+		t.Errorf("got error %v, want one naming $BLUE_SORT", err)
+	}
+}
+
+func TestLoadDefaultFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	c, err := Load("")
+	if err != nil {
+		t.Fatalf("a missing default file should be fine: %v", err)
+	}
+	if c.File != "" || c.Sort != Default().Sort {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	dir := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "blue")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("sort: title\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = Load(""); err != nil || c.Sort != "title" {
+		t.Errorf("config.yaml not read: %v, sort %q", err, c.Sort)
+	}
+}
@@ -4,23 +4,73 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha256"
+	"fmt"
 	"io"
 
 	"crypto/rand"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
-	saltSize   = 32
-	keySize    = 32
+	saltSize = 32
+	keySize  = 32
+
+	// legacy PBKDF2-SHA256 setting used by vaults written before the KDF
+	// was recorded in EncryptedData
 	iterations = 100_000
+
+	KDFArgon2id = "argon2id"
+	KDFPBKDF2   = "pbkdf2-sha256"
+)
+
+// KDFParams records which key derivation function produced the key and with
+// what cost, so Decrypt can reproduce it.
+type KDFParams struct {
+	Name        string `json:"name"`
+	Memory      uint32 `json:"memory,omitempty"` // KiB, argon2id only
+	Time        uint32 `json:"time,omitempty"`   // passes (argon2id) or iterations (pbkdf2)
+	Parallelism uint8  `json:"parallelism,omitempty"`
+}
+
+// DefaultKDF is used for everything Encrypt writes.
+var DefaultKDF = KDFParams{
+	Name:        KDFArgon2id,
+	Memory:      64 * 1024,
+	Time:        3,
+	Parallelism: 4,
+}
+
+// The most a vault file may ask of deriveKey. The parameters come from the
+// file, so a damaged or hostile one could otherwise make unlocking take
+// gigabytes of memory or hours.
+const (
+	maxArgon2Memory      = 1024 * 1024 // KiB, i.e. 1 GiB
+	maxArgon2Time        = 10
+	maxArgon2Parallelism = 16
+	maxPBKDF2Iterations  = 10_000_000
 )
 
+var legacyKDF = KDFParams{
+	Name: KDFPBKDF2,
+	Time: iterations,
+}
+
 type EncryptedData struct {
-	Salt       []byte `json:"salt"`
-	Nonce      []byte `json:"nonce"`
-	Ciphertext []byte `json:"ciphertext"`
+	KDF        *KDFParams `json:"kdf,omitempty"`
+	Salt       []byte     `json:"salt"`
+	Nonce      []byte     `json:"nonce"`
+	Ciphertext []byte     `json:"ciphertext"`
+}
+
+// Params returns the KDF parameters for e, treating a missing header as the
+// legacy PBKDF2 setting.
+func (e EncryptedData) Params() KDFParams {
+	if e.KDF == nil {
+		return legacyKDF
+	}
+	return *e.KDF
 }
 
 func generateSalt() ([]byte, error) {
@@ -29,25 +79,50 @@ func generateSalt() ([]byte, error) {
 	return salt, err
 }
 
-func deriveKey(pass string, salt []byte) []byte {
-	return pbkdf2.Key([]byte(pass), salt, iterations, keySize, sha256.New)
-
+func deriveKey(pass string, salt []byte, p KDFParams) ([]byte, error) {
+	switch p.Name {
+	case KDFArgon2id:
+		if p.Time == 0 || p.Memory == 0 || p.Parallelism == 0 {
+			return nil, fmt.Errorf("invalid argon2id parameters")
+		}
+		if p.Memory > maxArgon2Memory || p.Time > maxArgon2Time || p.Parallelism > maxArgon2Parallelism {
+			return nil, fmt.Errorf("argon2id parameters too costly: memory %d KiB, time %d, parallelism %d", p.Memory, p.Time, p.Parallelism)
+		}
+		return argon2.IDKey([]byte(pass), salt, p.Time, p.Memory, p.Parallelism, keySize), nil
+	case KDFPBKDF2:
+		if p.Time == 0 {
+			return nil, fmt.Errorf("invalid pbkdf2 parameters")
+		}
+		if p.Time > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("pbkdf2 parameters too costly: %d iterations", p.Time)
+		}
+		return pbkdf2.Key([]byte(pass), salt, int(p.Time), keySize, sha256.New), nil
+	default:
+		return nil, fmt.Errorf("unsupported kdf %q", p.Name)
+	}
 }
 
-func Encrypt(plaintext []byte, pass string) (*EncryptedData, error) {
-	salt, err := generateSalt()
-	if err != nil {
+// GenerateKey returns a random key suitable for Seal.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
 		return nil, err
 	}
+	return key, nil
+}
 
-	key := deriveKey(pass, salt)
-	defer clearBytes(key)
+// Seal encrypts plaintext with a raw AES-256 key. The result carries no salt
+// or KDF header.
+func Seal(plaintext, key []byte) (*EncryptedData, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
 	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
 
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
@@ -56,17 +131,13 @@ func Encrypt(plaintext []byte, pass string) (*EncryptedData, error) {
 	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
 
 	return &EncryptedData{
-		Salt:       salt,
 		Nonce:      nonce,
 		Ciphertext: ciphertext,
 	}, nil
-
 }
 
-func Decrypt(encr EncryptedData, pass string) ([]byte, error) {
-	key := deriveKey(pass, encr.Salt)
-	defer clearBytes(key)
-
+// Open decrypts data produced by Seal.
+func Open(encr EncryptedData, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -85,6 +156,61 @@ func Decrypt(encr EncryptedData, pass string) ([]byte, error) {
 	return plaintext, nil
 }
 
+func Encrypt(plaintext []byte, pass string) (*EncryptedData, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	params := DefaultKDF
+	key, err := deriveKey(pass, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	defer clearBytes(key)
+
+	encr, err := Seal(plaintext, key)
+	if err != nil {
+		return nil, err
+	}
+	encr.KDF = &params
+	encr.Salt = salt
+	return encr, nil
+}
+
+func Decrypt(encr EncryptedData, pass string) ([]byte, error) {
+	key, err := deriveKey(pass, encr.Salt, encr.Params())
+	if err != nil {
+		return nil, err
+	}
+	defer clearBytes(key)
+
+	return Open(encr, key)
+}
+
+// WrapKey encrypts a data key under a password-derived key.
+func WrapKey(dataKey []byte, pass string) (*EncryptedData, error) {
+	return Encrypt(dataKey, pass)
+}
+
+// UnwrapKey recovers a data key wrapped by WrapKey.
+func UnwrapKey(wrapped EncryptedData, pass string) ([]byte, error) {
+	key, err := Decrypt(wrapped, pass)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != keySize {
+		clearBytes(key)
+		return nil, fmt.Errorf("wrapped key has wrong length")
+	}
+	return key, nil
+}
+
+// Wipe zeroes b in place.
+func Wipe(b []byte) {
+	clearBytes(b)
+}
+
 func clearBytes(data []byte) {
 	for i := range data {
 		data[i] = 0
@@ -1,53 +1,88 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/electr1fy0/blue/cli"
+	"github.com/electr1fy0/blue/config"
 	"github.com/electr1fy0/blue/model"
-	"github.com/electr1fy0/blue/server"
+	"github.com/electr1fy0/blue/storage"
+	"github.com/electr1fy0/blue/utils"
 
-	"github.com/gorilla/websocket"
 	"golang.org/x/term"
 )
 
 func main() {
-	if !isatty() {
-		fmt.Fprintf(os.Stderr, "This program requires a terminal\n")
+	vaultName := flag.String("vault", "", "vault to open (default $BLUE_VAULT, then \"default\")")
+	configPath := flag.String("config", "", "config file (default $XDG_CONFIG_HOME/blue/config.toml)")
+	var overrides []string
+	flag.Func("set", "override a setting, as key=value", func(s string) error {
+		overrides = append(overrides, s)
+		return nil
+	})
+	flag.Usage = cli.Usage
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in config: %v\n", err)
 		os.Exit(1)
 	}
+	storage.LockTimeout = cfg.LockTimeout
+	storage.MaxBackups = cfg.MaxBackups
+	storage.MaxRevisions = cfg.MaxRevisions
+	storage.TrashRetention = time.Duration(cfg.TrashDays) * 24 * time.Hour
+	utils.Editor = cfg.Editor
+	utils.RequireRAM = cfg.RequireRAM
 
-	p := tea.NewProgram(
-		model.InitialModel(),
-		tea.WithAltScreen(),
-		tea.WithMouseCellMotion(),
-	)
+	if err := utils.CleanTemp(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: removing old temporary files: %v\n", err)
+	}
 
-	go func() {
-		time.Sleep(100 * time.Millisecond)
+	if err := storage.MigrateLegacyVault(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error moving ~/.blue-vault: %v\n", err)
+		os.Exit(1)
+	}
 
-		wsURL := "ws://localhost:8080/ws"
+	name := *vaultName
+	if name == "" {
+		name = os.Getenv("BLUE_VAULT")
+	}
+	if name != "" {
+		if err := storage.SetVault(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
-		if err != nil {
-			p.Send(server.WsError{Err: err})
-			return
+	if flag.NArg() > 0 {
+		if err := cli.Run(flag.Args(), cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		p.Send(server.WsConnected{Conn: conn})
+	if !isatty() {
+		fmt.Fprintf(os.Stderr, "This program requires a terminal\n")
+		os.Exit(1)
+	}
 
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				p.Send(server.WsError{Err: err})
-				_ = conn.Close()
-				return
-			}
-			p.Send(server.WsMessage{Data: msg})
-		}
-	}()
+	m, err := model.InitialModel(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in config: %v\n", err)
+		os.Exit(1)
+	}
+	p := tea.NewProgram(
+		m,
+		tea.WithAltScreen(),
+		tea.WithMouseCellMotion(),
+	)
 
 	if _, err := p.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -55,6 +90,24 @@ func main() {
 	}
 }
 
+// loadConfig reads the config file and applies --set overrides on top.
+func loadConfig(path string, overrides []string) (config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return cfg, err
+	}
+	for _, o := range overrides {
+		key, value, ok := strings.Cut(o, "=")
+		if !ok {
+			return cfg, fmt.Errorf("--set %s: want key=value", o)
+		}
+		if err := cfg.Set(key, value); err != nil {
+			return cfg, fmt.Errorf("--set: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
 func isatty() bool {
 	return term.IsTerminal(int(os.Stdin.Fd()))
 }
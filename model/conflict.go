@@ -0,0 +1,303 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/electr1fy0/blue/storage"
+	"github.com/electr1fy0/blue/utils"
+)
+
+// side is which version of a difference a merge keeps.
+type side int
+
+const (
+	sideMine side = iota
+	sideTheirs
+	sideBoth
+)
+
+// hunk is a run of lines the two versions share, or one where they differ.
+type hunk struct {
+	same   []string // set if both have these lines
+	mine   []string
+	theirs []string
+	keep   side
+}
+
+func (h hunk) differs() bool { return h.same == nil }
+
+// conflict is the state of the conflict screen: a note, a conflict copy of
+// it and which side of each difference the merge keeps.
+type conflict struct {
+	id, copyID string
+	hunks      []hunk
+	diffs      []int // indexes of the hunks that differ
+	cur        int   // index into diffs
+	trailingNL bool
+	from       state // screen to go back to
+}
+
+// findConflict returns the note id and a conflict copy of it, whichever of
+// the two id is.
+func (m *Model) findConflict(id string) (note, dup *storage.Note, ok bool) {
+	n, ok := m.nb.GetNote(id)
+	if !ok {
+		return nil, nil, false
+	}
+	if n.ConflictOf != "" {
+		orig, ok := m.nb.GetNote(n.ConflictOf)
+		return orig, n, ok
+	}
+	var copies []*storage.Note
+	for _, c := range m.nb.Notes {
+		if c.ConflictOf == id {
+			copies = append(copies, c)
+		}
+	}
+	if len(copies) == 0 {
+		return nil, nil, false
+	}
+	// oldest first, so several conflicts are resolved in order
+	slices.SortFunc(copies, func(a, b *storage.Note) int { return a.UpdatedAt.Compare(b.UpdatedAt) })
+	return n, copies[0], true
+}
+
+// openConflict shows the conflict screen for the note id or the conflict
+// copy id.
+func (m *Model) openConflict(id string) {
+	note, dup, ok := m.findConflict(id)
+	if !ok {
+		m.status = "No conflict to resolve"
+		if n, ok := m.nb.GetNote(id); ok && n.ConflictOf != "" {
+			m.status = "The note this is a conflict copy of is gone"
+		}
+		return
+	}
+	c := &conflict{id: note.ID, copyID: dup.ID, trailingNL: strings.HasSuffix(note.Content, "\n"), from: m.state}
+	for _, l := range utils.DiffLines(conflictLines(note.Content), conflictLines(dup.Content)) {
+		differs := l.Op != utils.DiffEqual
+		if len(c.hunks) == 0 || c.hunks[len(c.hunks)-1].differs() != differs {
+			c.hunks = append(c.hunks, hunk{})
+			if !differs {
+				c.hunks[len(c.hunks)-1].same = []string{}
+			}
+		}
+		h := &c.hunks[len(c.hunks)-1]
+		switch l.Op {
+		case utils.DiffEqual:
+			h.same = append(h.same, l.Text)
+		case utils.DiffDelete:
+			h.mine = append(h.mine, l.Text)
+		case utils.DiffInsert:
+			h.theirs = append(h.theirs, l.Text)
+		}
+	}
+	for i, h := range c.hunks {
+		if h.differs() {
+			c.diffs = append(c.diffs, i)
+		}
+	}
+	m.conflict = c
+	m.state = stateConflict
+}
+
+func conflictLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// merged returns the note with each difference resolved as chosen.
+func (c *conflict) merged() string {
+	var lines []string
+	for _, h := range c.hunks {
+		switch {
+		case !h.differs():
+			lines = append(lines, h.same...)
+		case h.keep == sideMine:
+			lines = append(lines, h.mine...)
+		case h.keep == sideTheirs:
+			lines = append(lines, h.theirs...)
+		default:
+			lines = append(lines, h.mine...)
+			lines = append(lines, h.theirs...)
+		}
+	}
+	s := strings.Join(lines, "\n")
+	if c.trailingNL && len(lines) > 0 {
+		s += "\n"
+	}
+	return s
+}
+
+// keep resolves the current difference, or with all set every difference,
+// as s.
+func (c *conflict) keep(s side, all bool) {
+	if len(c.diffs) == 0 {
+		return
+	}
+	if !all {
+		c.hunks[c.diffs[c.cur]].keep = s
+		if c.cur < len(c.diffs)-1 {
+			c.cur++
+		}
+		return
+	}
+	for _, i := range c.diffs {
+		c.hunks[i].keep = s
+	}
+}
+
+func (m *Model) updateConflict(msg tea.Msg) tea.Cmd {
+	c := m.conflict
+	km, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return nil
+	}
+	switch {
+	case key.Matches(km, m.keys.ForceQuit):
+		return m.quit()
+	case key.Matches(km, m.keys.Help):
+		m.help.ShowAll = !m.help.ShowAll
+	case key.Matches(km, m.keys.Up):
+		if c.cur > 0 {
+			c.cur--
+		}
+	case key.Matches(km, m.keys.Down):
+		if c.cur < len(c.diffs)-1 {
+			c.cur++
+		}
+	case key.Matches(km, m.keys.Mine):
+		c.keep(sideMine, false)
+	case key.Matches(km, m.keys.Theirs):
+		c.keep(sideTheirs, false)
+	case key.Matches(km, m.keys.Both):
+		c.keep(sideBoth, false)
+	case key.Matches(km, m.keys.AllMine):
+		c.keep(sideMine, true)
+	case key.Matches(km, m.keys.AllTheirs):
+		c.keep(sideTheirs, true)
+	case key.Matches(km, m.keys.Submit):
+		if m.writable() {
+			m.resolveConflict(c, c.merged())
+		}
+	case key.Matches(km, m.keys.Edit):
+		if !m.writable() {
+			break
+		}
+		note, ok := m.nb.GetNote(c.id)
+		if !ok {
+			break
+		}
+		return m.editText("Merging: "+note.Title, c.merged(), func(m *Model, content string) {
+			m.resolveConflict(c, content)
+		})
+	case key.Matches(km, m.keys.Cancel):
+		m.conflict = nil
+		m.state = c.from
+	}
+	return nil
+}
+
+// resolveConflict makes content the note's text and moves the conflict
+// copy to the trash.
+func (m *Model) resolveConflict(c *conflict, content string) {
+	m.conflict = nil
+	note, ok := m.nb.GetNote(c.id)
+	if !ok {
+		m.status = "Note not found"
+		m.state = stateList
+		return
+	}
+	note.SetContent(content)
+	note.Title = storage.ExtractTitle(content)
+	m.nb.DeleteNote(c.copyID)
+	m.persist()
+	m.refreshList()
+	m.current = note.ID
+	m.viewContent = m.renderNote(note.Content)
+	m.state = stateView
+	m.status = "Resolved conflict in " + note.Title
+}
+
+// conflictView shows both versions of each difference, marking the side
+// the merge keeps, scrolled to the current one.
+func (m *Model) conflictView() string {
+	c := m.conflict
+	note, nok := m.nb.GetNote(c.id)
+	dup, dok := m.nb.GetNote(c.copyID)
+	if !nok || !dok {
+		return errorStyle.Render("The conflicting notes changed; press esc")
+	}
+
+	var lines []string
+	focus := 0
+	for i, h := range c.hunks {
+		if !h.differs() {
+			for _, l := range h.same {
+				lines = append(lines, "  "+l)
+			}
+			continue
+		}
+		n := slices.Index(c.diffs, i)
+		current := n == c.cur
+		marker := "  "
+		if current {
+			marker = "▶ "
+			focus = len(lines)
+		}
+		header := fmt.Sprintf("%s%d/%d keeping %s", marker, n+1, len(c.diffs), [...]string{"mine", "theirs", "both"}[h.keep])
+		if current {
+			header = titleStyle.Render(header)
+		} else {
+			header = helpStyle.Render(header)
+		}
+		lines = append(lines, header)
+		for _, l := range h.mine {
+			lines = append(lines, conflictLine("< "+l, h.keep != sideTheirs, errorStyle.Render))
+		}
+		for _, l := range h.theirs {
+			lines = append(lines, conflictLine("> "+l, h.keep != sideMine, successStyle.Render))
+		}
+	}
+
+	// keep the current difference in view
+	height := max(m.height-8, 5)
+	start := max(min(focus-height/3, len(lines)-height), 0)
+	end := min(start+height, len(lines))
+
+	var s strings.Builder
+	s.WriteString(titleStyle.Render("Conflict: " + note.Title))
+	s.WriteString("\n")
+	s.WriteString(helpStyle.Render(fmt.Sprintf("< mine (%s)   > theirs (%s)", conflictSource(note), conflictSource(dup))))
+	s.WriteString("\n\n")
+	if len(c.diffs) == 0 {
+		s.WriteString(helpStyle.Render("(the versions are the same)"))
+		s.WriteString("\n")
+	}
+	s.WriteString(strings.Join(lines[start:end], "\n"))
+	s.WriteString("\n\n")
+	s.WriteString(m.helpView())
+	return s.String()
+}
+
+func conflictLine(l string, kept bool, style func(...string) string) string {
+	if !kept {
+		return helpStyle.Strikethrough(true).Render(l)
+	}
+	return style(l)
+}
+
+// conflictSource describes where and when a version was written.
+func conflictSource(n *storage.Note) string {
+	s := n.UpdatedAt.Format("2006-01-02 15:04")
+	if n.UpdatedBy != "" {
+		s = n.UpdatedBy + ", " + s
+	}
+	return s
+}
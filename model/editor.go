@@ -0,0 +1,420 @@
+package model
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/electr1fy0/blue/utils"
+)
+
+// maxUndo bounds the undo history of the built-in editor.
+const maxUndo = 500
+
+// previewDelay is how long the preview waits for typing to pause before
+// rendering again.
+const previewDelay = 150 * time.Millisecond
+
+// maxEditorLines is the most lines the built-in editor holds; the textarea
+// drops any past it.
+const maxEditorLines = 10000
+
+// indentUnit is what indent adds to, and outdent removes from, a line.
+const indentUnit = "  "
+
+// editor is the built-in editor: a textarea with markdown list handling,
+// undo and an optional live preview.
+type editor struct {
+	ta       textarea.Model
+	title    string
+	original string
+	back     state                // where closing the editor returns to
+	onSave   func(*Model, string) // called with the text when saved
+
+	undo   []snapshot
+	redo   []snapshot
+	typing bool // the last change was typing inside a word; undo groups it
+
+	preview    bool
+	previewSeq int // bumped on every change so stale renders are dropped
+	rendered   string
+
+	prompt bool // asking whether to save before closing
+}
+
+// snapshot is the text and cursor of the editor at one point in time.
+type snapshot struct {
+	text     string
+	row, col int
+}
+
+// previewMsg asks for the preview to be rendered again, unless the text
+// changed after it was sent.
+type previewMsg struct {
+	ed  *editor
+	seq int
+}
+
+// editText lets the user edit text and passes the result to onSave. It uses
+// the built-in editor unless editor_mode is external; when the external
+// editor can't be used it falls back to the built-in one.
+func (m *Model) editText(title, text string, onSave func(*Model, string)) tea.Cmd {
+	if m.cfg.EditorMode == "external" {
+		out, err := utils.OpenEditorWithContent(text)
+		switch {
+		case errors.Is(err, utils.ErrNoEditor):
+			m.status = "No external editor found, using the built-in one"
+		case errors.Is(err, utils.ErrNoRAMDir):
+			m.status = "No RAM-backed temp directory, using the built-in editor"
+		case err != nil:
+			m.status = "Editor failed: " + err.Error()
+			m.lastError = err.Error()
+			return tea.ClearScreen
+		default:
+			onSave(m, out)
+			return tea.ClearScreen
+		}
+	}
+
+	if strings.Count(text, "\n") >= maxEditorLines {
+		m.status = "Too long for the built-in editor; set editor_mode = external"
+		m.lastError = m.status
+		return nil
+	}
+
+	ta := textarea.New()
+	ta.ShowLineNumbers = false
+	ta.Prompt = ""
+	ta.CharLimit = 0
+	ta.MaxHeight = 0
+	ta.MaxWidth = 0
+	ta.SetValue(text)
+	moveCursor(&ta, 0, 0)
+
+	m.editor = &editor{
+		ta:       ta,
+		title:    title,
+		original: ta.Value(), // as the textarea cleaned it up, e.g. tabs
+
+		back:   m.state,
+		onSave: onSave,
+	}
+	m.resizeEditor()
+	m.state = stateEdit
+	return m.editor.ta.Focus()
+}
+
+// resizeEditor fits the editor to the window, leaving room for the title
+// and help, and half the width to the preview when it is shown.
+func (m *Model) resizeEditor() {
+	e := m.editor
+	if e == nil || m.width == 0 {
+		return
+	}
+	w := m.width
+	if e.preview {
+		w = m.width / 2
+	}
+	e.ta.SetWidth(w - 1)
+	e.ta.SetHeight(max(m.height-8, 3))
+}
+
+// updateEditor handles a message while the built-in editor is open.
+func (m *Model) updateEditor(msg tea.Msg) tea.Cmd {
+	e := m.editor
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.resizeEditor()
+		return m.schedulePreview()
+	case previewMsg:
+		if msg.ed == e && msg.seq == e.previewSeq && e.preview {
+			e.rendered = m.renderPreview(e.ta.Value())
+		}
+		return nil
+	case tea.KeyMsg:
+		if e.prompt {
+			return m.updateEditorPrompt(msg)
+		}
+		switch {
+		case key.Matches(msg, m.keys.Save):
+			m.closeEditor(true)
+			return nil
+		case key.Matches(msg, m.keys.Cancel, m.keys.ForceQuit):
+			if e.ta.Value() == e.original {
+				m.closeEditor(false)
+				return nil
+			}
+			e.prompt = true
+			return nil
+		case key.Matches(msg, m.keys.Preview):
+			e.preview = !e.preview
+			m.resizeEditor()
+			return m.schedulePreview()
+		case key.Matches(msg, m.keys.Undo):
+			e.restore(&e.undo, &e.redo)
+			return m.schedulePreview()
+		case key.Matches(msg, m.keys.Redo):
+			e.restore(&e.redo, &e.undo)
+			return m.schedulePreview()
+		case key.Matches(msg, m.keys.Indent):
+			e.edit(func(s snapshot) snapshot { return indentLine(s, true) })
+			return m.schedulePreview()
+		case key.Matches(msg, m.keys.Outdent):
+			e.edit(func(s snapshot) snapshot { return indentLine(s, false) })
+			return m.schedulePreview()
+		case key.Matches(msg, e.ta.KeyMap.InsertNewline):
+			if e.edit(continueList) {
+				return m.schedulePreview()
+			}
+		}
+	}
+
+	before := e.snapshot()
+	var cmd tea.Cmd
+	e.ta, cmd = e.ta.Update(msg)
+	km, isKey := msg.(tea.KeyMsg)
+	if e.ta.Value() == before.text {
+		if isKey {
+			e.typing = false // moved the cursor
+		}
+		return cmd
+	}
+	// typing a word is undone in one step; anything else is its own step
+	word := km.Type == tea.KeyRunes && len(km.Runes) == 1 && !strings.ContainsRune(" \t", km.Runes[0])
+	if !word || !e.typing {
+		e.push(before)
+	}
+	e.typing = word
+	return tea.Batch(cmd, m.schedulePreview())
+}
+
+// updateEditorPrompt handles the answer to "save before closing?".
+func (m *Model) updateEditorPrompt(msg tea.KeyMsg) tea.Cmd {
+	e := m.editor
+	switch {
+	case key.Matches(msg, m.keys.Cancel):
+		e.prompt = false
+	case key.Matches(msg, m.keys.Yes, m.keys.Save):
+		m.closeEditor(true)
+	case key.Matches(msg, m.keys.No):
+		m.closeEditor(false)
+	case key.Matches(msg, m.keys.ForceQuit):
+		m.closeEditor(false)
+		return m.quit()
+	}
+	return nil
+}
+
+// closeEditor leaves the built-in editor, saving its text first if save
+// is set.
+func (m *Model) closeEditor(save bool) {
+	e := m.editor
+	m.editor = nil
+	m.state = e.back
+	if !save {
+		m.status = "Discarded changes"
+		m.lastError = ""
+		return
+	}
+	if e.ta.Value() == e.original {
+		m.status = "No changes"
+		m.lastError = ""
+		return
+	}
+	e.onSave(m, e.ta.Value())
+}
+
+// schedulePreview renders the preview once typing pauses.
+func (m *Model) schedulePreview() tea.Cmd {
+	e := m.editor
+	if e == nil || !e.preview {
+		return nil
+	}
+	e.previewSeq++
+	msg := previewMsg{ed: e, seq: e.previewSeq}
+	return tea.Tick(previewDelay, func(time.Time) tea.Msg { return msg })
+}
+
+// renderPreview renders md for the preview pane. It never uses glow, which
+// is too slow to run on every pause in typing.
+func (m *Model) renderPreview(md string) string {
+	width := m.previewWidth() - 1 // the pane's padding
+	if m.cfg.Renderer != "plain" {
+		if out, err := renderMarkdown(md, width, m.themes[m.themeIdx].Glamour); err == nil {
+			return out
+		}
+	}
+	return renderMarkdownToANSI(md, width)
+}
+
+// previewWidth is the width of the preview pane inside its border.
+func (m *Model) previewWidth() int {
+	return m.width - m.width/2 - 2
+}
+
+// editorView draws the editor, with the preview beside it when shown.
+func (m *Model) editorView() string {
+	e := m.editor
+	var s strings.Builder
+	s.WriteString(titleStyle.Render(e.title))
+	if e.ta.Value() != e.original {
+		s.WriteString(helpStyle.Render(" (modified)"))
+	}
+	s.WriteString("\n\n")
+
+	body := e.ta.View()
+	if e.preview {
+		height := e.ta.Height()
+		lines := strings.Split(strings.TrimRight(e.rendered, "\n"), "\n")
+		// keep the preview roughly level with the cursor
+		if extra := len(lines) - height; extra > 0 {
+			top := extra * e.ta.Line() / max(e.ta.LineCount()-1, 1)
+			lines = lines[top:]
+		}
+		if len(lines) > height {
+			lines = lines[:height]
+		}
+		pane := lipgloss.NewStyle().
+			Width(m.width-m.width/2-2).
+			Height(height).
+			MaxHeight(height).
+			PaddingLeft(1).
+			Border(lipgloss.NormalBorder(), false, false, false, true).
+			BorderForeground(color(m.themes[m.themeIdx].Muted))
+		body = lipgloss.JoinHorizontal(lipgloss.Top, body, pane.Render(strings.Join(lines, "\n")))
+	}
+	s.WriteString(body)
+	s.WriteString("\n\n")
+	if e.prompt {
+		s.WriteString(warningStyle.Render("Save changes before closing?"))
+		s.WriteString("\n")
+	}
+	s.WriteString(m.helpView())
+	return s.String()
+}
+
+func (e *editor) snapshot() snapshot {
+	li := e.ta.LineInfo()
+	return snapshot{text: e.ta.Value(), row: e.ta.Line(), col: li.StartColumn + li.ColumnOffset}
+}
+
+func (e *editor) load(s snapshot) {
+	e.ta.SetValue(s.text)
+	moveCursor(&e.ta, s.row, s.col)
+}
+
+// push records s as the state to return to on undo.
+func (e *editor) push(s snapshot) {
+	e.undo = append(e.undo, s)
+	if len(e.undo) > maxUndo {
+		e.undo = e.undo[1:]
+	}
+	e.redo = nil
+}
+
+// restore goes back to the last state in from, saving the current one in
+// to: undo when from is the undo history, redo when it is the redo history.
+func (e *editor) restore(from, to *[]snapshot) {
+	if len(*from) == 0 {
+		return
+	}
+	last := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, e.snapshot())
+	e.load(last)
+	e.typing = false
+}
+
+// edit applies change to the text and cursor, recording it for undo, and
+// reports whether it changed anything.
+func (e *editor) edit(change func(snapshot) snapshot) bool {
+	before := e.snapshot()
+	after := change(before)
+	if after == before {
+		return false
+	}
+	e.push(before)
+	e.typing = false
+	e.load(after)
+	return true
+}
+
+// moveCursor puts the cursor of ta at col on row.
+func moveCursor(ta *textarea.Model, row, col int) {
+	for range ta.LineCount() * 64 {
+		if ta.Line() <= row {
+			break
+		}
+		ta.CursorUp()
+	}
+	ta.SetCursor(col)
+}
+
+// listItemPattern matches the marker of a markdown list item or quote:
+// indent, bullet or number, and an optional task box.
+var listItemPattern = regexp.MustCompile(`^(\s*)(?:([-*+])|(\d+)([.)])|(>))(\s+)(\[[ xX]\]\s+)?`)
+
+// continueList handles enter on a list item: the new line starts with the
+// next marker, and enter on an empty item ends the list. Elsewhere it
+// returns s unchanged.
+func continueList(s snapshot) snapshot {
+	lines := strings.Split(s.text, "\n")
+	line := []rune(lines[s.row])
+	m := listItemPattern.FindStringSubmatch(string(line))
+	if m == nil || s.col < len([]rune(m[0])) {
+		return s
+	}
+	if strings.TrimSpace(string(line[len([]rune(m[0])):])) == "" {
+		// an empty item: drop the marker instead
+		lines[s.row] = ""
+		return snapshot{text: strings.Join(lines, "\n"), row: s.row, col: 0}
+	}
+
+	indent, space := m[1], m[6]
+	var marker string
+	switch {
+	case m[2] != "":
+		marker = m[2]
+	case m[3] != "":
+		n, _ := strconv.Atoi(m[3])
+		marker = strconv.Itoa(n+1) + m[4]
+	default:
+		marker = m[5]
+	}
+	next := indent + marker + space
+	if m[7] != "" {
+		next += "[ ] "
+	}
+
+	head, tail := string(line[:s.col]), strings.TrimLeft(string(line[s.col:]), " ")
+	lines[s.row] = head
+	lines = append(lines[:s.row+1], append([]string{next + tail}, lines[s.row+1:]...)...)
+	return snapshot{text: strings.Join(lines, "\n"), row: s.row + 1, col: len([]rune(next))}
+}
+
+// indentLine indents the cursor's line by indentUnit, or outdents it by up
+// to that much, keeping the cursor on the same character.
+func indentLine(s snapshot, in bool) snapshot {
+	lines := strings.Split(s.text, "\n")
+	line := lines[s.row]
+	if in {
+		lines[s.row] = indentUnit + line
+		s.col += len(indentUnit)
+	} else {
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		n = min(n, len(indentUnit))
+		if n == 0 && strings.HasPrefix(line, "\t") {
+			n = 1
+		}
+		lines[s.row] = line[n:]
+		s.col = max(s.col-n, 0)
+	}
+	s.text = strings.Join(lines, "\n")
+	return s
+}
@@ -2,54 +2,98 @@ package model
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/electr1fy0/blue/storage"
 )
 
+func newPasswordInput(placeholder string) textinput.Model {
+	pi := textinput.New()
+	pi.Placeholder = placeholder
+	pi.Focus()
+	pi.CharLimit = 64
+	pi.Width = 30
+	pi.EchoMode = textinput.EchoPassword
+	pi.EchoCharacter = '•'
+	return pi
+}
+
 func (m *Model) changePassword(newPassword string) error {
-	if m.nb == nil {
+	if m.nb == nil || m.vault == nil {
 		return fmt.Errorf("no notebook loaded")
 	}
-	// Try saving with new password
-	if err := storage.SaveNotebook(m.nb, newPassword); err != nil {
+	// only the wrapped data key changes; notes stay as they are
+	if err := m.vault.ChangePassword(newPassword); err != nil {
 		return err
 	}
-	// If success, update in-memory password
-	m.password = newPassword
 	m.status = "Password changed."
 	return nil
 }
 
 func (m *Model) exportNotes() error {
-	exportDir := fmt.Sprintf("blue_export_%d", time.Now().Unix())
-	if err := os.MkdirAll(exportDir, 0755); err != nil {
+	exportDir := filepath.Join(m.cfg.ExportDir, fmt.Sprintf("blue_export_%d", time.Now().Unix()))
+	count, err := storage.ExportNotes(m.nb, exportDir)
+	if err != nil {
 		return err
 	}
+	m.status = fmt.Sprintf("Exported %d notes to %s/", count, exportDir)
+	return nil
+}
 
-	count := 0
-	for title, note := range m.nb.Notes {
-		filename := strings.ReplaceAll(title, "/", "_") + ".md"
-		path := filepath.Join(exportDir, filename)
-		if err := os.WriteFile(path, []byte(note.Content), 0644); err != nil {
-			return err
-		}
-		count++
+// currentTitle returns the title of the note open in stateView.
+func (m *Model) currentTitle() string {
+	if m.nb == nil {
+		return ""
 	}
+	if note, ok := m.nb.GetNote(m.current); ok {
+		return note.Title
+	}
+	return ""
+}
 
-	m.status = fmt.Sprintf("Exported %d notes to %s/", count, exportDir)
-	return nil
+// addNote opens the editor on a new note, which is added when saved.
+func (m *Model) addNote() tea.Cmd {
+	initial := storage.BuildContentWithMeta(storage.Meta{}, "# New note\n\nStart writing here...\n")
+	return m.editText("New note", initial, func(m *Model, content string) {
+		n := &storage.Note{
+			Title:     storage.ExtractTitle(content),
+			Content:   content,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+		m.nb.AddNote(n)
+		m.persist()
+		m.refreshList()
+		m.status = "Added note: " + n.Title
+	})
 }
 
-func (m *Model) persist() {
-	if err := storage.SaveNotebook(m.nb, m.password); err != nil {
-		m.status = "Failed to save: " + err.Error()
-		m.lastError = err.Error()
-		return
+// editNote opens the editor on the note with the given ID.
+func (m *Model) editNote(id string) tea.Cmd {
+	note, ok := m.nb.GetNote(id)
+	if !ok {
+		m.status = "Note not found"
+		m.state = stateList
+		return nil
 	}
-	m.status = "Saved."
-	m.lastError = ""
+	return m.editText("Editing: "+note.Title, note.Content, func(m *Model, content string) {
+		// the note may have been deleted by a reload while it was open
+		note, ok := m.nb.GetNote(id)
+		if !ok {
+			m.status = "Note not found"
+			m.state = stateList
+			return
+		}
+		note.SetContent(content)
+		note.Title = storage.ExtractTitle(content)
+		m.persist()
+		m.refreshList()
+		if m.state == stateView {
+			m.viewContent = m.renderNote(note.Content)
+		}
+		m.status = "Edited " + note.Title
+	})
 }
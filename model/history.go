@@ -0,0 +1,50 @@
+package model
+
+import (
+	"strings"
+
+	"github.com/electr1fy0/blue/storage"
+	"github.com/electr1fy0/blue/utils"
+)
+
+// revisionAt returns the i-th revision counting back from the newest.
+func revisionAt(note *storage.Note, i int) storage.Revision {
+	return note.Revisions[len(note.Revisions)-1-i]
+}
+
+// restoreRevision makes the selected revision the current content. The
+// version it replaces goes into history, so a restore can be undone.
+func (m *Model) restoreRevision(note *storage.Note, i int) {
+	if i < 0 || i >= len(note.Revisions) {
+		return
+	}
+	rev := revisionAt(note, i)
+	note.SetContent(rev.Content)
+	note.Title = storage.ExtractTitle(rev.Content)
+	m.persist()
+	m.refreshList()
+	m.viewContent = m.renderNote(note.Content)
+	m.status = "Restored version from " + rev.UpdatedAt.Format("2006-01-02 15:04")
+	m.state = stateView
+}
+
+func renderDiff(lines []utils.DiffLine) string {
+	if len(lines) == 0 {
+		return helpStyle.Render("(no differences)")
+	}
+	var s strings.Builder
+	for _, l := range lines {
+		switch l.Op {
+		case utils.DiffHunk:
+			s.WriteString(titleStyle.Render(l.Text))
+		case utils.DiffInsert:
+			s.WriteString(successStyle.Render("+" + l.Text))
+		case utils.DiffDelete:
+			s.WriteString(errorStyle.Render("-" + l.Text))
+		default:
+			s.WriteString(" " + l.Text)
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
@@ -0,0 +1,416 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/electr1fy0/blue/config"
+)
+
+// KeyMap holds every key binding of the notebook UI. Bindings are named in
+// the config file by their action, e.g. key.add = "a,n".
+type KeyMap struct {
+	// moving around
+	Up       key.Binding
+	Down     key.Binding
+	PageUp   key.Binding
+	PageDown key.Binding
+	Top      key.Binding
+	Bottom   key.Binding
+	Open     key.Binding
+	Back     key.Binding
+
+	// everywhere
+	Quit      key.Binding
+	ForceQuit key.Binding
+	Save      key.Binding
+	Help      key.Binding
+	Theme     key.Binding
+	Lock      key.Binding
+
+	// notes
+	Add            key.Binding
+	Edit           key.Binding
+	Delete         key.Binding
+	Pin            key.Binding
+	Favorite       key.Binding
+	Tags           key.Binding
+	Archive        key.Binding
+	History        key.Binding
+	Search         key.Binding
+	ClearSearch    key.Binding
+	Sort           key.Binding
+	Export         key.Binding
+	ShowArchived   key.Binding
+	Trash          key.Binding
+	ChangePassword key.Binding
+	SwitchVault    key.Binding
+	Resolve        key.Binding
+
+	// built-in editor
+	Undo    key.Binding
+	Redo    key.Binding
+	Indent  key.Binding
+	Outdent key.Binding
+	Preview key.Binding
+
+	// conflict screen
+	Mine      key.Binding
+	Theirs    key.Binding
+	Both      key.Binding
+	AllMine   key.Binding
+	AllTheirs key.Binding
+
+	// trash and history
+	Restore        key.Binding
+	RestoreVersion key.Binding
+	Purge          key.Binding
+	EmptyTrash     key.Binding
+	Diff           key.Binding
+
+	// prompts
+	Yes      key.Binding
+	No       key.Binding
+	Submit   key.Binding
+	Cancel   key.Binding
+	ReadOnly key.Binding
+	Takeover key.Binding
+
+	// password screen
+	NextVault key.Binding
+	PrevVault key.Binding
+	NewVault  key.Binding
+	Recover   key.Binding
+}
+
+func bind(desc string, keys ...string) key.Binding {
+	return key.NewBinding(key.WithKeys(keys...), key.WithHelp(strings.Join(keys, "/"), desc))
+}
+
+// DefaultKeyMap returns the standard bindings.
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Up:       bind("up", "up", "k"),
+		Down:     bind("down", "down", "j"),
+		PageUp:   bind("prev page", "pgup", "left"),
+		PageDown: bind("next page", "pgdown", "right"),
+		Top:      bind("first", "home"),
+		Bottom:   bind("last", "end"),
+		Open:     bind("view", "enter"),
+		Back:     bind("back", "esc", "b"),
+
+		Quit:      bind("quit", "q"),
+		ForceQuit: bind("quit", "ctrl+c"),
+		Save:      bind("save", "ctrl+s"),
+		Help:      bind("more keys", "?"),
+		Theme:     bind("next theme", "ctrl+t"),
+		Lock:      bind("lock", "ctrl+l"),
+
+		Add:            bind("add", "a"),
+		Edit:           bind("edit", "e"),
+		Delete:         bind("delete", "d"),
+		Pin:            bind("pin", "p"),
+		Favorite:       bind("favorite", "f"),
+		Tags:           bind("tags", "t"),
+		Archive:        bind("archive", "r"),
+		History:        bind("history", "h"),
+		Search:         bind("search", "/"),
+		ClearSearch:    bind("clear search", "c"),
+		Sort:           bind("sort", "s"),
+		Export:         bind("export", "e"),
+		ShowArchived:   bind("toggle archived", "g"),
+		Trash:          bind("trash", "T"),
+		ChangePassword: bind("change password", "P"),
+		SwitchVault:    bind("switch vault", "V"),
+		Resolve:        bind("resolve conflict", "C"),
+
+		Undo:    bind("undo", "ctrl+z"),
+		Redo:    bind("redo", "ctrl+y"),
+		Indent:  bind("indent", "tab"),
+		Outdent: bind("outdent", "shift+tab"),
+		Preview: bind("preview", "ctrl+o"),
+
+		Mine:      bind("mine", "o"),
+		Theirs:    bind("theirs", "t"),
+		Both:      bind("both", "b"),
+		AllMine:   bind("all mine", "O"),
+		AllTheirs: bind("all theirs", "T"),
+
+		Restore:        bind("restore", "u", "enter"),
+		RestoreVersion: bind("restore version", "r"),
+		Purge:          bind("delete forever", "d"),
+		EmptyTrash:     bind("empty trash", "E"),
+		Diff:           bind("diff", "enter"),
+
+		Yes:      bind("confirm", "y", "Y"),
+		No:       bind("cancel", "n", "N", "esc"),
+		Submit:   bind("continue", "enter"),
+		Cancel:   bind("back", "esc"),
+		ReadOnly: bind("read-only", "r"),
+		Takeover: bind("take over", "t"),
+
+		NextVault: bind("switch vault", "tab"),
+		PrevVault: bind("previous vault", "shift+tab"),
+		NewVault:  bind("new vault", "ctrl+n"),
+		Recover:   bind("forgot password", "ctrl+r"),
+	}
+}
+
+// VimKeyMap adds vim motions to the standard bindings.
+func VimKeyMap() KeyMap {
+	k := DefaultKeyMap()
+	k.PageUp = bind("prev page", "ctrl+b", "ctrl+u", "pgup")
+	k.PageDown = bind("next page", "ctrl+f", "ctrl+d", "pgdown")
+	k.Bottom = bind("last", "G", "end")
+	k.Open = bind("view", "l", "enter")
+	k.Back = bind("back", "esc", "h")
+	k.History = bind("history", "H")
+	k.Add = bind("add", "o", "a")
+	k.Edit = bind("edit", "i", "e")
+	k.Delete = bind("delete", "x", "d")
+	return k
+}
+
+// EmacsKeyMap uses emacs movement keys.
+func EmacsKeyMap() KeyMap {
+	k := DefaultKeyMap()
+	k.Up = bind("up", "ctrl+p", "up")
+	k.Down = bind("down", "ctrl+n", "down")
+	k.PageUp = bind("prev page", "alt+v", "pgup")
+	k.PageDown = bind("next page", "ctrl+v", "pgdown")
+	k.Top = bind("first", "alt+<", "home")
+	k.Bottom = bind("last", "alt+>", "end")
+	k.Back = bind("back", "ctrl+g", "esc")
+	k.Cancel = bind("back", "ctrl+g", "esc")
+	k.No = bind("cancel", "n", "N", "ctrl+g", "esc")
+	k.Delete = bind("delete", "ctrl+d", "d")
+	k.Search = bind("search", "ctrl+s", "/")
+	k.Save = bind("save", "ctrl+x")
+	return k
+}
+
+// actions names each binding for the config file.
+func (k *KeyMap) actions() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"up": &k.Up, "down": &k.Down, "page_up": &k.PageUp, "page_down": &k.PageDown,
+		"top": &k.Top, "bottom": &k.Bottom, "open": &k.Open, "back": &k.Back,
+		"quit": &k.Quit, "force_quit": &k.ForceQuit, "save": &k.Save, "help": &k.Help, "theme": &k.Theme, "lock": &k.Lock,
+		"add": &k.Add, "edit": &k.Edit, "delete": &k.Delete, "pin": &k.Pin,
+		"favorite": &k.Favorite, "tags": &k.Tags, "archive": &k.Archive, "history": &k.History,
+		"search": &k.Search, "clear_search": &k.ClearSearch, "sort": &k.Sort, "export": &k.Export,
+		"show_archived": &k.ShowArchived, "trash": &k.Trash, "change_password": &k.ChangePassword,
+		"switch_vault": &k.SwitchVault, "resolve": &k.Resolve, "undo": &k.Undo, "redo": &k.Redo, "indent": &k.Indent,
+		"outdent": &k.Outdent, "preview": &k.Preview, "mine": &k.Mine, "theirs": &k.Theirs,
+		"both": &k.Both, "all_mine": &k.AllMine, "all_theirs": &k.AllTheirs, "restore": &k.Restore, "restore_version": &k.RestoreVersion, "purge": &k.Purge,
+		"empty_trash": &k.EmptyTrash, "diff": &k.Diff, "yes": &k.Yes, "no": &k.No,
+		"submit": &k.Submit, "cancel": &k.Cancel, "read_only": &k.ReadOnly, "takeover": &k.Takeover,
+		"next_vault": &k.NextVault, "prev_vault": &k.PrevVault, "new_vault": &k.NewVault,
+		"recover": &k.Recover,
+	}
+}
+
+// screens lists the bindings active together on each screen; a key may
+// only be used once per screen.
+func (k *KeyMap) screens() map[string][]*key.Binding {
+	nav := []*key.Binding{&k.Up, &k.Down, &k.PageUp, &k.PageDown, &k.Top, &k.Bottom}
+	always := []*key.Binding{&k.Quit, &k.ForceQuit, &k.Help, &k.Theme, &k.Lock}
+	return map[string][]*key.Binding{
+		"notes": slices.Concat(nav, always, []*key.Binding{&k.Open, &k.Save, &k.Add, &k.Delete,
+			&k.Pin, &k.Favorite, &k.Tags, &k.Search, &k.ClearSearch, &k.Sort, &k.Export,
+			&k.ShowArchived, &k.Trash, &k.ChangePassword, &k.SwitchVault, &k.Resolve}),
+		"note view": slices.Concat(always, []*key.Binding{&k.Back, &k.Save, &k.Edit, &k.Delete,
+			&k.Pin, &k.Favorite, &k.Tags, &k.Archive, &k.History, &k.Resolve}),
+		"editor":  {&k.Save, &k.Cancel, &k.ForceQuit, &k.Lock, &k.Undo, &k.Redo, &k.Indent, &k.Outdent, &k.Preview},
+		"trash":   slices.Concat(nav, always, []*key.Binding{&k.Back, &k.Trash, &k.Restore, &k.Purge, &k.EmptyTrash}),
+		"history": {&k.Up, &k.Down, &k.Diff, &k.RestoreVersion, &k.Back, &k.ForceQuit, &k.Lock},
+		"conflict": {&k.Up, &k.Down, &k.Mine, &k.Theirs, &k.Both, &k.AllMine, &k.AllTheirs,
+			&k.Submit, &k.Edit, &k.Cancel, &k.Help, &k.ForceQuit, &k.Lock},
+		"confirm":  {&k.Yes, &k.No},
+		"password": {&k.NextVault, &k.PrevVault, &k.NewVault, &k.Recover, &k.Submit, &k.ForceQuit},
+		"lock":     {&k.ReadOnly, &k.Takeover, &k.No, &k.ForceQuit},
+	}
+}
+
+// NewKeyMap returns the preset named in cfg with cfg's key overrides
+// applied. It fails on unknown actions and on keys bound twice on one
+// screen.
+func NewKeyMap(cfg config.Config) (KeyMap, error) {
+	var k KeyMap
+	switch cfg.Keymap {
+	case "", "default":
+		k = DefaultKeyMap()
+	case "vim":
+		k = VimKeyMap()
+	case "emacs":
+		k = EmacsKeyMap()
+	default:
+		return k, fmt.Errorf("keymap: %q is not one of default, vim, emacs", cfg.Keymap)
+	}
+
+	actions := k.actions()
+	for name, keys := range cfg.Keys {
+		b, ok := actions[name]
+		if !ok {
+			return k, fmt.Errorf("key.%s: unknown action", name)
+		}
+		desc := b.Help().Desc
+		var ks []string
+		for s := range strings.SplitSeq(keys, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				ks = append(ks, s)
+			}
+		}
+		if len(ks) == 0 {
+			*b = key.NewBinding(key.WithDisabled(), key.WithHelp("", desc))
+			continue
+		}
+		*b = bind(desc, ks...)
+	}
+
+	names := make(map[*key.Binding]string, len(actions))
+	for name, b := range actions {
+		names[b] = name
+	}
+	screens := k.screens()
+	order := make([]string, 0, len(screens))
+	for s := range screens {
+		order = append(order, s)
+	}
+	sort.Strings(order)
+	for _, screen := range order {
+		used := make(map[string]*key.Binding)
+		for _, b := range screens[screen] {
+			for _, s := range b.Keys() {
+				if other, ok := used[s]; ok && other != b {
+					a, c := names[other], names[b]
+					if a > c {
+						a, c = c, a
+					}
+					return k, fmt.Errorf("key %q is bound to both %s and %s on the %s screen", s, a, c, screen)
+				}
+				used[s] = b
+			}
+		}
+	}
+	return k, nil
+}
+
+// listKeyMap hands the navigation bindings to the list, so its defaults
+// (which include letters) don't shadow note actions.
+func (k KeyMap) listKeyMap() list.KeyMap {
+	lk := list.DefaultKeyMap()
+	lk.CursorUp = k.Up
+	lk.CursorDown = k.Down
+	lk.PrevPage = k.PageUp
+	lk.NextPage = k.PageDown
+	lk.GoToStart = k.Top
+	lk.GoToEnd = k.Bottom
+	off := key.NewBinding(key.WithDisabled())
+	lk.Filter, lk.ClearFilter = off, off
+	lk.ShowFullHelp, lk.CloseFullHelp = off, off
+	lk.Quit, lk.ForceQuit = off, off
+	return lk
+}
+
+// helpKeys is a set of bindings shown by the help line; the first row is the
+// short help.
+type helpKeys [][]key.Binding
+
+func (h helpKeys) ShortHelp() []key.Binding {
+	if len(h) == 0 {
+		return nil
+	}
+	return h[0]
+}
+
+func (h helpKeys) FullHelp() [][]key.Binding { return h }
+
+// helpKeys returns the bindings to describe on the current screen.
+func (m *Model) helpKeys() helpKeys {
+	k := m.keys
+	switch m.state {
+	case statePass:
+		next := k.NextVault
+		next.SetEnabled(len(m.vaults) > 1)
+		return helpKeys{{k.Submit, next, k.NewVault, k.Recover, k.ForceQuit}}
+	case stateLockConflict:
+		return helpKeys{{k.ReadOnly, k.Takeover, k.No}}
+	case stateConfirm, stateRecoveryOffer:
+		return helpKeys{{k.Yes, k.No}}
+	case stateNewVault, stateRecover, stateSearch, stateChangePass:
+		return helpKeys{{k.Submit, k.Cancel}}
+	case stateRecoveryShow:
+		return helpKeys{{k.Submit}}
+	case stateList:
+		if m.showTrash {
+			back := k.Trash
+			back.SetHelp(strings.Join(append(k.Trash.Keys(), k.Back.Keys()...), "/"), "back")
+			return helpKeys{
+				{k.Restore, k.Purge, k.EmptyTrash, back, k.Quit, k.Help},
+				{k.Up, k.Down, k.PageUp, k.PageDown, k.Top, k.Bottom},
+			}
+		}
+		clear := k.ClearSearch
+		clear.SetEnabled(m.searchTerm != "")
+		save := k.Save
+		save.SetEnabled(!m.cfg.Autosave)
+		return helpKeys{
+			{k.Add, k.Delete, k.Open, k.Search, clear, save, k.Quit, k.Help},
+			{k.Pin, k.Favorite, k.Tags, k.Sort, k.Export},
+			{k.ShowArchived, k.Trash, k.ChangePassword, k.SwitchVault, k.Lock, k.Theme},
+			{k.Up, k.Down, k.PageUp, k.PageDown, k.Top, k.Bottom, k.Resolve},
+		}
+	case stateView:
+		save := k.Save
+		save.SetEnabled(!m.cfg.Autosave)
+		return helpKeys{
+			{k.Edit, k.Delete, k.Back, save, k.Quit, k.Help},
+			{k.Pin, k.Favorite, k.Tags, k.Archive, k.History, k.Resolve, k.Lock, k.Theme},
+		}
+	case stateEdit:
+		if m.editor != nil && m.editor.prompt {
+			yes, no, cancel := k.Yes, k.No, k.Cancel
+			yes.SetHelp(yes.Help().Key, "save")
+			var discard []string
+			for _, s := range no.Keys() {
+				if !slices.Contains(cancel.Keys(), s) {
+					discard = append(discard, s)
+				}
+			}
+			no.SetHelp(strings.Join(discard, "/"), "discard")
+			cancel.SetHelp(cancel.Help().Key, "keep editing")
+			return helpKeys{{yes, no, cancel}}
+		}
+		// "?" is text here, so everything goes on one line
+		return helpKeys{{k.Save, k.Cancel, k.Preview, k.Undo, k.Redo, k.Indent, k.Outdent}}
+	case stateHistory:
+		return helpKeys{{k.Up, k.Down, k.Diff, k.RestoreVersion, k.Back}}
+	case stateDiff:
+		return helpKeys{{k.RestoreVersion, k.Back}}
+	case stateConflict:
+		save, edit := k.Submit, k.Edit
+		save.SetHelp(save.Help().Key, "save merge")
+		edit.SetHelp(edit.Help().Key, "edit merge")
+		return helpKeys{
+			{k.Mine, k.Theirs, k.Both, save, k.Cancel, k.Help},
+			{k.Up, k.Down, k.AllMine, k.AllTheirs, edit},
+		}
+	}
+	return nil
+}
+
+// helpView renders the help line, or every binding after "?".
+func (m *Model) helpView() string {
+	return m.help.View(m.helpKeys())
+}
+
+func newHelp() help.Model {
+	h := help.New()
+	h.ShortSeparator = "  "
+	return h
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/electr1fy0/blue/config"
+)
+
+func TestNewKeyMapPresets(t *testing.T) {
+	for _, preset := range []string{"default", "vim", "emacs"} {
+		if _, err := NewKeyMap(config.Config{Keymap: preset}); err != nil {
+			t.Errorf("%s: %v", preset, err)
+		}
+	}
+	if _, err := NewKeyMap(config.Config{Keymap: "nano"}); err == nil {
+		t.Error("an unknown preset was accepted")
+	}
+}
+
+func TestNewKeyMapOverrides(t *testing.T) {
+	k, err := NewKeyMap(config.Config{Keys: map[string]string{
+		"add":    "n, a",
+		"export": "",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(k.Add.Keys(), ","); got != "n,a" {
+		t.Errorf("add bound to %q, want n,a", got)
+	}
+	if k.Export.Enabled() {
+		t.Error("export is still bound")
+	}
+	if k.Add.Help().Desc == "" || k.Export.Help().Desc == "" {
+		t.Error("rebinding lost the help text")
+	}
+}
+
+func TestNewKeyMapErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		keymap string
+		keys   map[string]string
+		want   string
+	}{
+		{"unknown action", "", map[string]string{"fly": "x"}, "key.fly: unknown action"},
+		// d deletes in the list
+		{"same screen", "", map[string]string{"add": "d"}, `key "d" is bound to both add and delete on the notes screen`},
+		{"same screen twice", "", map[string]string{"pin": "x", "favorite": "x"}, `key "x" is bound to both favorite and pin`},
+		{"preset", "vim", map[string]string{"search": "j"}, `key "j" is bound to both down and search`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyMap(config.Config{Keymap: tt.keymap, Keys: tt.keys})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKeyMapOtherScreens(t *testing.T) {
+	// o keeps my side of a conflict, a screen without the add action
+	if _, err := NewKeyMap(config.Config{Keys: map[string]string{"add": "o"}}); err != nil {
+		t.Errorf("a key used on different screens was refused: %v", err)
+	}
+}
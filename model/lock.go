@@ -0,0 +1,132 @@
+package model
+
+import (
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/electr1fy0/blue/storage"
+)
+
+// idleMsg asks whether the vault has been idle long enough to lock.
+type idleMsg struct {
+	saver *saver
+}
+
+// resumePoint is where the user was when the vault locked, to go back to
+// once it is unlocked again.
+type resumePoint struct {
+	vault        string
+	state        state
+	current      string // open note
+	selected     string // selected list item
+	historyIdx   int
+	searchTerm   string
+	showArchived bool
+	showTrash    bool
+}
+
+// idleTimer returns the command that checks for inactivity after d, or nil
+// if auto-lock is off.
+func (m *Model) idleTimer(d time.Duration) tea.Cmd {
+	if m.cfg.AutoLock <= 0 || m.saver == nil {
+		return nil
+	}
+	s := m.saver
+	return tea.Tick(d, func(time.Time) tea.Msg { return idleMsg{s} })
+}
+
+// checkIdle locks the vault if there was no input for the auto_lock
+// duration, and otherwise waits for the rest of it.
+func (m *Model) checkIdle() tea.Cmd {
+	idle := time.Since(m.lastActivity)
+	if idle < m.cfg.AutoLock {
+		return m.idleTimer(m.cfg.AutoLock - idle)
+	}
+	if m.state == stateRecoveryOffer || m.state == stateRecoveryShow {
+		// the recovery key is only shown once; wait until it's dealt with
+		return m.idleTimer(m.cfg.AutoLock)
+	}
+	m.lock()
+	m.status = "Locked after " + m.cfg.AutoLock.String() + " without activity"
+	return textinput.Blink
+}
+
+// lock saves and closes the vault, wipes the notes and key from memory and
+// goes back to the password screen, remembering what was open.
+func (m *Model) lock() {
+	if m.editor != nil {
+		// keep what was typed rather than lose it
+		m.closeEditor(true)
+	}
+	r := &resumePoint{
+		vault:        storage.CurrentVault(),
+		state:        stateList,
+		current:      m.current,
+		historyIdx:   m.historyIdx,
+		searchTerm:   m.searchTerm,
+		showArchived: m.showArchived,
+		showTrash:    m.showTrash,
+	}
+	switch m.state {
+	case stateView, stateHistory:
+		r.state = m.state
+	case stateDiff:
+		r.state = stateHistory
+	case stateConflict:
+		r.state = stateView
+		if m.conflict.from == stateList {
+			r.state = stateList
+		}
+	}
+	if it := m.list.SelectedItem(); it != nil {
+		r.selected = it.(listItem).id
+	}
+
+	m.closeVault()
+	m.renderCache = nil
+	m.allItems = nil
+	m.diffContent = ""
+	m.conflict = nil
+	m.confirmAction = nil
+	m.searchInput.SetValue("")
+
+	m.resume = r
+	m.loadVaults()
+	m.pwInput = newPasswordInput("enter password")
+	m.status = "Locked vault " + r.vault
+	m.lastError = ""
+	m.state = statePass
+}
+
+// resumeView returns to where the user was when the vault locked, if it
+// was this vault.
+func (m *Model) resumeView() {
+	r := m.resume
+	m.resume = nil
+	if r == nil || r.vault != storage.CurrentVault() {
+		return
+	}
+	m.showTrash = r.showTrash
+	m.showArchived = r.showArchived
+	m.searchTerm = r.searchTerm
+	m.refreshList()
+	for i, it := range m.list.Items() {
+		if it.(listItem).id == r.selected {
+			m.list.Select(i)
+			break
+		}
+	}
+
+	note, ok := m.nb.GetNote(r.current)
+	if !ok || r.state == stateList {
+		return
+	}
+	m.current = r.current
+	m.viewContent = m.renderNote(note.Content)
+	m.state = stateView
+	if r.state == stateHistory && r.historyIdx < len(note.Revisions) {
+		m.historyIdx = r.historyIdx
+		m.state = stateHistory
+	}
+}
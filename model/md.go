@@ -7,6 +7,7 @@ import (
 	"os/exec"
 
 	markdown "github.com/MichaelMure/go-term-markdown"
+	"github.com/electr1fy0/blue/utils"
 )
 
 func renderMarkdownToANSI(md string, width int) string {
@@ -17,35 +18,23 @@ func renderMarkdownToANSI(md string, width int) string {
 	return string(out)
 }
 
-func renderWithGlow(md string) (string, error) {
+func renderWithGlow(md, style string) (string, error) {
 	glowPath, err := exec.LookPath("glow")
 	if err != nil {
 		return "", fmt.Errorf("glow not found")
 	}
 
-	tmp, err := os.CreateTemp("", "note-*.md")
+	tmpName, err := utils.WriteTemp("render-*.md", md)
 	if err != nil {
 		return "", err
 	}
-	tmpName := tmp.Name()
-	defer func() {
-		_ = tmp.Close()
-		_ = os.Remove(tmpName)
-	}()
+	defer utils.RemoveTemp(tmpName)
 
-	if _, err := tmp.WriteString(md); err != nil {
-		tmp.Close()
-		return "", err
-	}
-	if err := tmp.Close(); err != nil {
-		return "", err
-	}
-
-	cmd := exec.Command(glowPath, "-s", "dark", tmpName)
+	cmd := exec.Command(glowPath, "-s", style, tmpName)
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
 	cmd.Stderr = &buf
-	cmd.Env = append(os.Environ(), "GLOW_STYLE=dark")
+	cmd.Env = append(os.Environ(), "GLOW_STYLE="+style)
 
 	if err := cmd.Run(); err != nil {
 		return "", err
@@ -53,6 +42,30 @@ func renderWithGlow(md string) (string, error) {
 	return buf.String(), nil
 }
 
+// renderNote renders note content with the configured renderer in the
+// current theme's style. "auto" tries glow, then glamour; whatever fails
+// falls back to the plain terminal renderer.
+func (m *Model) renderNote(content string) string {
+	theme := m.themes[m.themeIdx]
+	if m.cfg.Renderer == "auto" || m.cfg.Renderer == "glow" {
+		style, err := m.cfg.GlowStyle, error(nil)
+		if style == "" {
+			style, err = theme.glowStyle()
+		}
+		if err == nil {
+			if out, err := renderWithGlow(content, style); err == nil {
+				return out
+			}
+		}
+	}
+	if m.cfg.Renderer == "auto" || m.cfg.Renderer == "glamour" {
+		if out, err := renderMarkdown(content, m.width, theme.Glamour); err == nil {
+			return out
+		}
+	}
+	return renderMarkdownToANSI(content, m.width)
+}
+
 // // Put this function right after the renderMarkdown function (around line 60-70)
 // func (m *Model) renderNoteContent(content string) string {
 // 	// Try renderWithGlow with timeout
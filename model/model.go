@@ -1,15 +1,16 @@
 package model
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
+	"github.com/electr1fy0/blue/config"
 	"github.com/electr1fy0/blue/server"
 	"github.com/electr1fy0/blue/storage"
 	"github.com/electr1fy0/blue/utils"
@@ -28,6 +29,9 @@ func (i listItem) Title() string {
 	if i.archived {
 		prefixParts = append(prefixParts, "(ARC)")
 	}
+	if i.conflict {
+		prefixParts = append(prefixParts, "(CONFLICT)")
+	}
 	if len(prefixParts) > 0 {
 		return strings.Join(prefixParts, " ") + " " + i.title
 	}
@@ -36,19 +40,22 @@ func (i listItem) Title() string {
 
 func (i listItem) Description() string {
 	var description string = fmt.Sprintf("Updated: %s", i.updatedAt.Format("2006-01-02 15:04"))
+	if !i.deletedAt.IsZero() {
+		description = fmt.Sprintf("Deleted: %s", i.deletedAt.Format("2006-01-02 15:04"))
+	}
 	if len(i.tags) > 0 {
 		description += " • tags: " + strings.Join(i.tags, ",")
 	}
 	return description
 }
 
-func renderMarkdown(md string, width int) (string, error) {
+func renderMarkdown(md string, width int, style []byte) (string, error) {
 	if width < 40 {
 		width = 40
 	}
 
 	r, err := glamour.NewTermRenderer(
-		glamour.WithAutoStyle(),
+		glamour.WithStylesFromJSONBytes(style),
 		glamour.WithWordWrap(width-4),
 	)
 	if err != nil {
@@ -63,14 +70,23 @@ func renderMarkdown(md string, width int) (string, error) {
 	return out, nil
 }
 
-func InitialModel() Model {
-	ti := textinput.New()
-	ti.Placeholder = "enter password"
-	ti.Focus()
-	ti.CharLimit = 64
-	ti.Width = 30
-	ti.EchoMode = textinput.EchoPassword
-	ti.EchoCharacter = '•'
+// InitialModel returns the UI on the password screen. It fails if the key
+// bindings or themes in cfg are unusable.
+func InitialModel(cfg config.Config) (Model, error) {
+	keys, err := NewKeyMap(cfg)
+	if err != nil {
+		return Model{}, err
+	}
+	themes, err := loadThemes(cfg)
+	if err != nil {
+		return Model{}, err
+	}
+	theme, err := findTheme(themes, cfg.Theme)
+	if err != nil {
+		return Model{}, err
+	}
+
+	ti := newPasswordInput("enter password")
 
 	si := textinput.New()
 	si.Placeholder = "search notes..."
@@ -81,112 +97,137 @@ func InitialModel() Model {
 	l.Title = "Notes"
 	l.SetShowStatusBar(false)
 	l.SetFilteringEnabled(false)
-	l.DisableQuitKeybindings()
+	l.SetShowHelp(false)
+	l.KeyMap = keys.listKeyMap()
 
-	return Model{
+	sortBy := sortByDate
+	if cfg.Sort == "title" {
+		sortBy = sortByTitle
+	}
+
+	m := Model{
+		cfg:         cfg,
+		keys:        keys,
+		help:        newHelp(),
+		themes:      themes,
 		state:       statePass,
 		pwInput:     ti,
 		searchInput: si,
 		list:        l,
-		sortBy:      sortByDate,
+		sortBy:      sortBy,
 		wsStatus:    "disconnected",
 	}
+	m.setTheme(theme)
+	m.loadVaults()
+	return m, nil
 }
 
 func (m Model) Init() tea.Cmd {
 	return textinput.Blink
 }
 
+// Update handles msg and then starts saving anything it changed.
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	next, cmd := m.update(msg)
+	nm := next.(Model)
+	if save := nm.scheduleSave(); save != nil {
+		return nm, tea.Batch(cmd, save)
+	}
+	return nm, cmd
+}
 
+func (m Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
 		m.list.SetWidth(msg.Width - 4)
 		m.list.SetHeight(msg.Height - 8)
+		m.help.Width = msg.Width
 
 		if m.state == stateView && m.current != "" && m.nb != nil {
 			if note, exists := m.nb.GetNote(m.current); exists {
-				if out, err := renderWithGlow(note.Content); err == nil {
-					m.viewContent = out
-				} else if out, err := renderMarkdown(note.Content, m.width); err == nil {
-					m.viewContent = out
-				} else {
-					m.viewContent = renderMarkdownToANSI(note.Content, m.width)
-				}
+				m.viewContent = m.renderNote(note.Content)
 			}
 		}
 	}
 
 	switch msg := msg.(type) {
+	case tea.KeyMsg, tea.MouseMsg:
+		m.lastActivity = time.Now()
+	case idleMsg:
+		if msg.saver != m.saver || m.nb == nil {
+			return m, nil
+		}
+		return m, m.checkIdle()
+	case autosaveMsg:
+		if msg.saver != m.saver {
+			return m, nil
+		}
+		return m, m.saveNow()
+	case savedMsg:
+		if msg.saver != m.saver || msg.err == nil {
+			return m, nil
+		}
+		return m, m.handleSaveError(msg.err)
+	case vaultChangedMsg:
+		if msg.ch != m.watchCh {
+			return m, nil
+		}
+		return m, tea.Batch(waitForChange(msg.ch), m.reload())
+	case reloadedMsg:
+		if msg.saver == m.saver {
+			m.applyReload(msg)
+		}
+		return m, nil
 	case server.WsConnected:
+		if m.nb == nil {
+			// locked or switched vault while dialing
+			_ = msg.Conn.Close()
+			return m, nil
+		}
 		m.ws = msg.Conn
 		m.wsStatus = "connected"
+		m.pending = make(map[string]string)
 		m.status = "Connected to sync server"
+		m.sayHello()
+		if m.ws == nil {
+			return m, nil
+		}
+		return m, server.Listen(m.ws)
 	case server.WsError:
-		m.wsStatus = "disconnected"
+		if msg.Conn != nil && msg.Conn != m.ws {
+			// a connection we already dropped
+			return m, nil
+		}
+		m.resetSync()
 		m.lastError = msg.Err.Error()
 		m.status = "WebSocket error: " + msg.Err.Error()
-
-		m.ws = nil
 	case server.WsMessage:
-
-		if m.nb == nil {
+		if msg.Conn != m.ws || m.nb == nil {
 			return m, nil
 		}
+		m.applyWsMessage(msg.Data)
+		return m, server.Listen(m.ws)
+	}
 
-		var notes []storage.Note
-		if err := json.Unmarshal(msg.Data, &notes); err == nil && len(notes) > 0 {
+	if km, ok := msg.(tea.KeyMsg); ok && m.nb != nil && key.Matches(km, m.keys.Lock) &&
+		m.state != stateRecoveryOffer && m.state != stateRecoveryShow {
+		m.lock()
+		return m, textinput.Blink
+	}
 
-			m.nb.Notes = make(map[string]*storage.Note, len(notes))
-			for _, n := range notes {
-				nn := n
-				m.nb.Notes[nn.Title] = &nn
-			}
-			m.persist()
-			m.refreshList()
-			m.status = fmt.Sprintf("Synced %d notes from server", len(notes))
+	if km, ok := msg.(tea.KeyMsg); ok && (m.state == stateList || m.state == stateView) {
+		switch {
+		case key.Matches(km, m.keys.Help):
+			m.help.ShowAll = !m.help.ShowAll
 			return m, nil
-		}
-
-		var wmsg WSMessage
-		if err := json.Unmarshal(msg.Data, &wmsg); err != nil {
+		case key.Matches(km, m.keys.Theme):
+			m.setTheme((m.themeIdx + 1) % len(m.themes))
+			m.status = "Theme: " + m.themes[m.themeIdx].Name
+			m.lastError = ""
 			return m, nil
 		}
-
-		switch wmsg.Type {
-		case "add":
-			n := wmsg.Note
-			nn := n
-
-			if _, ok := m.nb.Notes[nn.Title]; !ok {
-				m.nb.AddNote(nn)
-				m.persist()
-				m.refreshList()
-				m.status = "Remote add: " + nn.Title
-			}
-		case "edit":
-			n := wmsg.Note
-			if wmsg.OldTitle != "" && wmsg.OldTitle != n.Title {
-
-				delete(m.nb.Notes, wmsg.OldTitle)
-			}
-			nn := n
-			m.nb.Notes[nn.Title] = nn
-			m.persist()
-			m.refreshList()
-			m.status = "Remote edit: " + nn.Title
-		case "delete":
-			t := wmsg.Note.Title
-			if t != "" {
-				delete(m.nb.Notes, t)
-				m.persist()
-				m.refreshList()
-				m.status = "Remote delete: " + t
-			}
-		default:
-		}
 	}
 
 	switch m.state {
@@ -195,11 +236,48 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.pwInput, cmd = m.pwInput.Update(msg)
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.String() {
-			case "ctrl+c":
+			switch {
+			case key.Matches(msg, m.keys.ForceQuit):
 				return m, tea.Quit
-			case "enter":
-				m.password = m.pwInput.Value()
+			case key.Matches(msg, m.keys.NextVault, m.keys.PrevVault):
+				if len(m.vaults) > 1 {
+					step := 1
+					if key.Matches(msg, m.keys.PrevVault) {
+						step = len(m.vaults) - 1
+					}
+					m.selectVault((m.vaultIdx + step) % len(m.vaults))
+				}
+				return m, nil
+			case key.Matches(msg, m.keys.NewVault):
+				vi := textinput.New()
+				vi.Placeholder = "vault name"
+				vi.Focus()
+				vi.CharLimit = 64
+				vi.Width = 30
+				m.vaultInput = vi
+				m.status = ""
+				m.lastError = ""
+				m.state = stateNewVault
+				return m, textinput.Blink
+			case key.Matches(msg, m.keys.Recover):
+				exists, err := storage.NotebookExists()
+				if err != nil || !exists {
+					m.status = "No notebook to recover"
+					return m, nil
+				}
+				ri := textinput.New()
+				ri.Placeholder = "XXXX-XXXX-..."
+				ri.Focus()
+				ri.CharLimit = 64
+				ri.Width = 50
+				m.recoverInput = ri
+				m.recoverKey = ""
+				m.status = ""
+				m.lastError = ""
+				m.state = stateRecover
+				return m, textinput.Blink
+			case key.Matches(msg, m.keys.Submit):
+				password := m.pwInput.Value()
 				exists, err := storage.NotebookExists()
 				if err != nil {
 					m.status = "Error checking notebook: " + err.Error()
@@ -207,25 +285,149 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 				}
 				if exists {
-					nb, err := storage.LoadNotebook(m.password)
-					if err != nil {
-						m.status = "Failed to decrypt: " + err.Error()
-						m.lastError = err.Error()
-						m.pwInput.SetValue("")
-						return m, nil
-					}
-					m.nb = nb
-				} else {
-					m.nb = storage.NewNotebook()
-					if err := storage.SaveNotebook(m.nb, m.password); err != nil {
-						m.status = "Failed to create notebook: " + err.Error()
-						m.lastError = err.Error()
-						return m, nil
+					return m, tea.Batch(cmd, m.openVault(storage.OpenExclusive))
+				}
+				vault, nb, err := storage.CreateVault(password)
+				if err != nil {
+					m.status = "Failed to create notebook: " + err.Error()
+					m.lastError = err.Error()
+					return m, nil
+				}
+				sync := m.unlocked(vault, nb)
+				m.status = "Created notebook"
+				m.state = stateRecoveryOffer
+				return m, sync
+			}
+		}
+		return m, cmd
+
+	case stateLockConflict:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keys.ForceQuit):
+				return m, tea.Quit
+			case key.Matches(msg, m.keys.ReadOnly):
+				return m, m.openVault(storage.OpenReadOnly)
+			case key.Matches(msg, m.keys.Takeover):
+				return m, m.openVault(storage.OpenTakeover)
+			case key.Matches(msg, m.keys.No):
+				m.pwInput.SetValue("")
+				m.status = ""
+				m.lastError = ""
+				m.state = statePass
+			}
+		}
+		return m, nil
+
+	case stateNewVault:
+		var cmd tea.Cmd
+		m.vaultInput, cmd = m.vaultInput.Update(msg)
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keys.ForceQuit):
+				return m, tea.Quit
+			case key.Matches(msg, m.keys.Cancel):
+				m.state = statePass
+			case key.Matches(msg, m.keys.Submit):
+				name := strings.TrimSpace(m.vaultInput.Value())
+				if err := storage.ValidateVaultName(name); err != nil {
+					m.status = err.Error()
+					m.lastError = err.Error()
+					break
+				}
+				if err := storage.SetVault(name); err != nil {
+					m.status = err.Error()
+					m.lastError = err.Error()
+					break
+				}
+				m.loadVaults()
+				m.pwInput = newPasswordInput("choose a password")
+				m.status = "Choose a password for new vault " + name
+				m.lastError = ""
+				m.state = statePass
+			}
+		}
+		return m, cmd
+
+	case stateRecoveryOffer:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keys.Yes):
+				key, err := m.vault.AddRecoveryKey()
+				if err != nil {
+					m.status = "Failed to create recovery key: " + err.Error()
+					m.lastError = err.Error()
+					m.state = stateList
+					break
+				}
+				m.recoveryKey = key
+				m.state = stateRecoveryShow
+			case key.Matches(msg, m.keys.No):
+				m.state = stateList
+			}
+		}
+		return m, nil
+
+	case stateRecoveryShow:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keys.Submit, m.keys.Cancel):
+				m.recoveryKey = ""
+				m.status = "Recovery key saved to vault"
+				m.state = stateList
+				return m, tea.ClearScreen
+			}
+		}
+		return m, nil
+
+	case stateRecover:
+		var cmd tea.Cmd
+		if m.recoverKey == "" {
+			m.recoverInput, cmd = m.recoverInput.Update(msg)
+		} else {
+			m.pwInput, cmd = m.pwInput.Update(msg)
+		}
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keys.ForceQuit):
+				return m, tea.Quit
+			case key.Matches(msg, m.keys.Cancel):
+				m.recoverKey = ""
+				m.recoverInput.SetValue("")
+				m.pwInput = newPasswordInput("enter password")
+				m.state = statePass
+			case key.Matches(msg, m.keys.Submit):
+				if m.recoverKey == "" {
+					if strings.TrimSpace(m.recoverInput.Value()) == "" {
+						break
 					}
+					m.recoverKey = m.recoverInput.Value()
+					m.recoverInput.SetValue("")
+					m.pwInput = newPasswordInput("enter new password")
+					break
 				}
-				m.refreshList()
+				newpw := m.pwInput.Value()
+				if newpw == "" {
+					break
+				}
+				vault, nb, err := storage.RecoverVault(m.recoverKey, newpw)
+				m.recoverKey = ""
+				m.pwInput = newPasswordInput("enter password")
+				if err != nil {
+					m.status = "Recovery failed: " + err.Error()
+					m.lastError = err.Error()
+					m.state = statePass
+					break
+				}
+				sync := m.unlocked(vault, nb)
+				m.status = "Recovered notebook and set new password"
 				m.state = stateList
-				m.status = fmt.Sprintf("Loaded notebook (%d notes)", len(m.nb.Notes))
+				return m, sync
 			}
 		}
 		return m, cmd
@@ -235,13 +437,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.searchInput, cmd = m.searchInput.Update(msg)
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.String() {
-			case "enter":
+			switch {
+			case key.Matches(msg, m.keys.Submit):
 				m.searchTerm = m.searchInput.Value()
 				m.refreshList()
 				m.state = stateList
 				m.status = fmt.Sprintf("Search: '%s' (%d results)", m.searchTerm, len(m.allItems))
-			case "esc":
+			case key.Matches(msg, m.keys.Cancel):
 				m.searchTerm = ""
 				m.searchInput.SetValue("")
 				m.refreshList()
@@ -253,13 +455,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case stateConfirm:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.String() {
-			case "y", "Y":
+			switch {
+			case key.Matches(msg, m.keys.Yes):
+				m.state = stateList
 				if m.confirmAction != nil {
-					m.confirmAction()
+					m.confirmAction(&m)
 				}
-				m.state = stateList
-			case "n", "N", "esc":
+			case key.Matches(msg, m.keys.No):
 				m.state = stateList
 			}
 		}
@@ -269,25 +471,27 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		var cmd tea.Cmd
 		m.list, cmd = m.list.Update(msg)
 
+		if m.showTrash {
+			return m.updateTrash(msg, cmd)
+		}
+
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.String() {
-			case "ctrl+c", "q":
-				if m.ws != nil {
-					_ = m.ws.Close()
-					m.ws = nil
-				}
-				return m, tea.Quit
-			case "/":
+			switch {
+			case key.Matches(msg, m.keys.Quit, m.keys.ForceQuit):
+				return m, m.quit()
+			case key.Matches(msg, m.keys.Save):
+				return m, m.saveNow()
+			case key.Matches(msg, m.keys.Search):
 				m.searchInput.Focus()
 				m.state = stateSearch
-			case "c":
+			case key.Matches(msg, m.keys.ClearSearch):
 				if m.searchTerm != "" {
 					m.searchTerm = ""
 					m.refreshList()
 					m.status = "Cleared search"
 				}
-			case "s":
+			case key.Matches(msg, m.keys.Sort):
 				if m.sortBy == sortByTitle {
 					m.sortBy = sortByDate
 					m.status = "Sorted by date"
@@ -296,96 +500,59 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.status = "Sorted by title"
 				}
 				m.refreshList()
-			case "e":
+			case key.Matches(msg, m.keys.Export):
 				if err := m.exportNotes(); err != nil {
 					m.status = "Export failed: " + err.Error()
 					m.lastError = err.Error()
 				}
-			case "a":
-				content, err := utils.OpenEditorWithContent(buildContentWithMeta(noteMeta{}, "# New note\n\nStart writing here...\n"))
-				if err != nil {
-					m.status = "Editor failed: " + err.Error()
-					m.lastError = err.Error()
+			case key.Matches(msg, m.keys.Add):
+				if !m.writable() {
 					break
 				}
-				title := extractTitle(content)
-
-				originalTitle := title
-				counter := 1
-				for {
-					if _, exists := m.nb.Notes[title]; !exists {
-						break
-					}
-					title = fmt.Sprintf("%s_%d", originalTitle, counter)
-					counter++
-				}
-
-				n := &storage.Note{
-					Title:     title,
-					Content:   content,
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
-				}
-				m.nb.AddNote(n)
-				m.persist()
-				m.refreshList()
-				m.status = "Added note: " + title
-
-				if m.ws != nil {
-					wm := WSMessage{Type: "add", Note: n}
-					if err := m.ws.WriteJSON(wm); err != nil {
-						m.lastError = err.Error()
-						m.status = "WS write failed: " + err.Error()
-						_ = m.ws.Close()
-						m.ws = nil
-						m.wsStatus = "disconnected"
-					}
+				return m, m.addNote()
+			case key.Matches(msg, m.keys.Delete):
+				if !m.writable() {
+					break
 				}
-			case "d":
 
 				if it := m.list.SelectedItem(); it != nil {
-					name := it.(listItem).title
-					m.confirmMsg = fmt.Sprintf("Delete note '%s'? (y/N)", name)
-					nm := name
-					m.confirmAction = func() {
-						if m.nb.DeleteNote(nm) {
+					item := it.(listItem)
+					m.confirmMsg = fmt.Sprintf("Move note '%s' to trash? (y/N)", item.title)
+					m.confirmAction = func(m *Model) {
+						if m.nb.DeleteNote(item.id) {
 							m.persist()
 							m.refreshList()
-							m.status = "Deleted: " + nm
-							// notify server
-							if m.ws != nil {
-								wm := WSMessage{Type: "delete", Note: &storage.Note{Title: nm}}
-								if err := m.ws.WriteJSON(wm); err != nil {
-									m.lastError = err.Error()
-									m.status = "WS write failed: " + err.Error()
-									_ = m.ws.Close()
-									m.ws = nil
-									m.wsStatus = "disconnected"
-								}
-							}
+							m.status = "Moved to trash: " + item.title
 						}
 					}
 					m.state = stateConfirm
 				}
-			case "enter":
+			case key.Matches(msg, m.keys.Open):
 				if it := m.list.SelectedItem(); it != nil {
-					name := it.(listItem).title
-					m.current = name
-					note, exists := m.nb.GetNote(name)
+					item := it.(listItem)
+					m.current = item.id
+					note, exists := m.nb.GetNote(item.id)
 					if !exists {
-						m.status = "Note not found: " + name
+						m.status = "Note not found: " + item.title
 						break
 					}
-					if out, err := renderWithGlow(note.Content); err == nil {
-						m.viewContent = out
-					} else if out, err := renderMarkdown(note.Content, m.width); err == nil {
-						m.viewContent = out
-					} else {
-						m.viewContent = renderMarkdownToANSI(note.Content, m.width)
-					}
+					m.viewContent = m.renderNote(note.Content)
 					m.state = stateView
 				}
-			case "g":
+			case key.Matches(msg, m.keys.SwitchVault):
+				name := storage.CurrentVault()
+				m.closeVault()
+				m.loadVaults()
+				m.pwInput = newPasswordInput("enter password")
+				m.status = "Locked vault " + name
+				m.lastError = ""
+				m.state = statePass
+				return m, textinput.Blink
+			case key.Matches(msg, m.keys.Trash):
+				m.showTrash = true
+				m.status = fmt.Sprintf("Showing trash (%d notes)", len(m.nb.Trash))
+				m.refreshList()
+			case key.Matches(msg, m.keys.ShowArchived):
 				m.showArchived = !m.showArchived
 				if m.showArchived {
 					m.status = "Showing archived notes"
@@ -393,92 +560,44 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.status = "Showing active notes"
 				}
 				m.refreshList()
-			case "P":
-				pi := textinput.New()
-				pi.Placeholder = "enter new password"
-				pi.Focus()
-				pi.CharLimit = 64
-				pi.Width = 30
-				pi.EchoMode = textinput.EchoPassword
-				pi.EchoCharacter = '•'
-				m.pwInput = pi
+			case key.Matches(msg, m.keys.ChangePassword):
+				if !m.writable() {
+					break
+				}
+				m.pwInput = newPasswordInput("enter new password")
 				m.state = stateChangePass
-			case "p":
+			case key.Matches(msg, m.keys.Pin):
+				if !m.writable() {
+					break
+				}
 				if it := m.list.SelectedItem(); it != nil {
-					name := it.(listItem).title
-					m.updateNoteMeta(name, func(meta *noteMeta) {
+					item := it.(listItem)
+					m.updateNoteMeta(item.id, func(meta *storage.Meta) {
 						meta.Pinned = !meta.Pinned
 					})
-					m.status = "Toggled pin: " + name
-					if m.ws != nil {
-						if note, ok := m.nb.GetNote(name); ok {
-							wm := WSMessage{Type: "edit", Note: note, OldTitle: note.Title}
-							if err := m.ws.WriteJSON(wm); err != nil {
-								m.lastError = err.Error()
-								m.status = "WS write failed: " + err.Error()
-								_ = m.ws.Close()
-								m.ws = nil
-								m.wsStatus = "disconnected"
-							}
-						}
-					}
+					m.status = "Toggled pin: " + item.title
+				}
+			case key.Matches(msg, m.keys.Favorite):
+				if !m.writable() {
+					break
 				}
-			case "f":
 				if it := m.list.SelectedItem(); it != nil {
-					name := it.(listItem).title
-					m.updateNoteMeta(name, func(meta *noteMeta) {
+					item := it.(listItem)
+					m.updateNoteMeta(item.id, func(meta *storage.Meta) {
 						meta.Favorite = !meta.Favorite
 					})
-					m.status = "Toggled favorite: " + name
-					if m.ws != nil {
-						if note, ok := m.nb.GetNote(name); ok {
-							wm := WSMessage{Type: "edit", Note: note, OldTitle: note.Title}
-							if err := m.ws.WriteJSON(wm); err != nil {
-								m.lastError = err.Error()
-								m.status = "WS write failed: " + err.Error()
-								_ = m.ws.Close()
-								m.ws = nil
-								m.wsStatus = "disconnected"
-							}
-						}
-					}
+					m.status = "Toggled favorite: " + item.title
+				}
+			case key.Matches(msg, m.keys.Tags):
+				if !m.writable() {
+					break
 				}
-			case "t":
 				if it := m.list.SelectedItem(); it != nil {
-					name := it.(listItem).title
-					if note, ok := m.nb.GetNote(name); ok {
-						meta, body := parseFrontMatter(note.Content)
-						initial := "tags: " + strings.Join(meta.Tags, ",") + "\n\n# edit tags as comma-separated values above\n"
-						out, err := utils.OpenEditorWithContent(initial)
-						if err != nil {
-							m.status = "Editor failed: " + err.Error()
-							m.lastError = err.Error()
-							break
-						}
-						lines := strings.Split(out, "\n")
-						if len(lines) > 0 {
-							first := strings.TrimSpace(lines[0])
-							if strings.HasPrefix(strings.ToLower(first), "tags:") {
-								val := strings.TrimSpace(first[len("tags:"):])
-								val = strings.Trim(val, "[] ")
-								if val == "" {
-									meta.Tags = []string{}
-								} else {
-									parts := strings.Split(val, ",")
-									for i := range parts {
-										parts[i] = strings.TrimSpace(parts[i])
-									}
-									meta.Tags = parts
-								}
-								// rebuild content with the same body
-								note.Content = buildContentWithMeta(meta, body)
-								note.UpdatedAt = time.Now()
-								m.persist()
-								m.refreshList()
-								m.status = "Updated tags for " + name
-							}
-						}
-					}
+					return m, m.editTags(it.(listItem).id)
+				}
+			case key.Matches(msg, m.keys.Resolve):
+				if it := m.list.SelectedItem(); it != nil {
+					m.openConflict(it.(listItem).id)
 				}
 			}
 		}
@@ -487,156 +606,149 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case stateView:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.String() {
-			case "ctrl+c", "q":
-
-				if m.ws != nil {
-					_ = m.ws.Close()
-					m.ws = nil
-				}
-				return m, tea.Quit
-			case "b", "esc":
+			switch {
+			case key.Matches(msg, m.keys.Quit, m.keys.ForceQuit):
+				return m, m.quit()
+			case key.Matches(msg, m.keys.Save):
+				return m, m.saveNow()
+			case key.Matches(msg, m.keys.Back):
 				m.state = stateList
-			case "e":
-				note, exists := m.nb.GetNote(m.current)
-				if !exists {
-					m.status = "Note not found: " + m.current
-					m.state = stateList
-					return m, tea.ClearScreen
-				}
-
-				content, err := utils.OpenEditorWithContent(note.Content)
-				if err != nil {
-					m.status = "Editor failed: " + err.Error()
-					m.lastError = err.Error()
-					return m, tea.ClearScreen
-				}
-
-				oldTitle := note.Title
-				note.Content = content
-				note.UpdatedAt = time.Now()
-
-				newTitle := extractTitle(content)
-				if newTitle != oldTitle {
-					delete(m.nb.Notes, oldTitle)
-					note.Title = newTitle
-					m.nb.Notes[newTitle] = note
-					m.current = newTitle
-				} else {
-					m.nb.Notes[m.current] = note
+			case key.Matches(msg, m.keys.Edit):
+				if !m.writable() {
+					break
 				}
-
-				m.persist()
-				m.refreshList()
-
-				if m.width > 0 && m.height > 0 {
-					m.list.SetWidth(m.width - 4)
-					m.list.SetHeight(m.height - 8)
+				return m, m.editNote(m.current)
+			case key.Matches(msg, m.keys.Delete):
+				if !m.writable() {
+					break
 				}
-
-				m.status = "Edited " + m.current
-
-				m.state = stateList
-				return m, tea.ClearScreen
-			case "d":
 				// Only allow delete when in view mode
-				m.confirmMsg = fmt.Sprintf("Delete note '%s'? (y/N)", m.current)
 				cur := m.current
-				m.confirmAction = func() {
+				title := m.currentTitle()
+				m.confirmMsg = fmt.Sprintf("Move note '%s' to trash? (y/N)", title)
+				m.confirmAction = func(m *Model) {
 					if m.nb.DeleteNote(cur) {
 						m.persist()
 						m.refreshList()
-						m.status = "Deleted: " + cur
-						m.state = stateList
-						// notify server
-						if m.ws != nil {
-							wm := WSMessage{Type: "delete", Note: &storage.Note{Title: cur}}
-							if err := m.ws.WriteJSON(wm); err != nil {
-								m.lastError = err.Error()
-								m.status = "WS write failed: " + err.Error()
-								_ = m.ws.Close()
-								m.ws = nil
-								m.wsStatus = "disconnected"
-							}
-						}
+						m.status = "Moved to trash: " + title
 					}
 				}
 				m.state = stateConfirm
-			case "p":
+			case key.Matches(msg, m.keys.Pin):
+				if !m.writable() {
+					break
+				}
 
-				name := m.current
-				m.updateNoteMeta(name, func(meta *noteMeta) {
+				m.updateNoteMeta(m.current, func(meta *storage.Meta) {
 					meta.Pinned = !meta.Pinned
 				})
-				m.status = "Toggled pin: " + name
-			case "f":
-				name := m.current
-				m.updateNoteMeta(name, func(meta *noteMeta) {
+				m.status = "Toggled pin: " + m.currentTitle()
+			case key.Matches(msg, m.keys.Favorite):
+				if !m.writable() {
+					break
+				}
+				m.updateNoteMeta(m.current, func(meta *storage.Meta) {
 					meta.Favorite = !meta.Favorite
 				})
-				m.status = "Toggled favorite: " + name
-			case "t":
-				name := m.current
-				if note, ok := m.nb.GetNote(name); ok {
-					meta, _ := parseFrontMatter(note.Content)
-					initial := "tags: " + strings.Join(meta.Tags, ",") + "\n\n# edit tags as comma-separated values above\n"
-					out, err := utils.OpenEditorWithContent(initial)
-					if err != nil {
-						m.status = "Editor failed: " + err.Error()
-						m.lastError = err.Error()
-						break
-					}
-					lines := strings.Split(out, "\n")
-					if len(lines) > 0 {
-						first := strings.TrimSpace(lines[0])
-						if strings.HasPrefix(strings.ToLower(first), "tags:") {
-							val := strings.TrimSpace(first[len("tags:"):])
-							val = strings.Trim(val, "[] ")
-							if val == "" {
-								meta.Tags = []string{}
-							} else {
-								parts := strings.Split(val, ",")
-								for i := range parts {
-									parts[i] = strings.TrimSpace(parts[i])
-								}
-								meta.Tags = parts
-							}
-							_, body2 := parseFrontMatter(note.Content)
-							note.Content = buildContentWithMeta(meta, body2)
-							note.UpdatedAt = time.Now()
-							m.persist()
-							m.refreshList()
-
-							if m.width > 0 && m.height > 0 {
-								m.list.SetWidth(m.width - 4)
-								m.list.SetHeight(m.height - 8)
-							}
-							if rendered, err := renderMarkdown(note.Content, m.width); err == nil {
-								m.viewContent = rendered
-							} else {
-								m.viewContent = "Error rendering markdown: " + err.Error()
-							}
-							m.status = "Updated tags for " + name
-							return m, tea.ClearScreen
-						}
-					}
+				m.status = "Toggled favorite: " + m.currentTitle()
+			case key.Matches(msg, m.keys.Tags):
+				if !m.writable() {
+					break
+				}
+				return m, m.editTags(m.current)
+			case key.Matches(msg, m.keys.Archive):
+				if !m.writable() {
+					break
 				}
-			case "r":
-				name := m.current
-				m.updateNoteMeta(name, func(meta *noteMeta) {
+				m.updateNoteMeta(m.current, func(meta *storage.Meta) {
 					meta.Archived = !meta.Archived
 				})
-				m.status = "Toggled archive: " + name
+				m.status = "Toggled archive: " + m.currentTitle()
+			case key.Matches(msg, m.keys.History):
+				note, ok := m.nb.GetNote(m.current)
+				if !ok {
+					break
+				}
+				if len(note.Revisions) == 0 {
+					m.status = "No earlier versions of " + note.Title
+					break
+				}
+				m.historyIdx = 0
+				m.state = stateHistory
+			case key.Matches(msg, m.keys.Resolve):
+				m.openConflict(m.current)
 			}
 		}
 		return m, nil
+
+	case stateHistory:
+		note, ok := m.nb.GetNote(m.current)
+		if !ok {
+			m.state = stateList
+			return m, nil
+		}
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keys.ForceQuit):
+				return m, m.quit()
+			case key.Matches(msg, m.keys.Up):
+				if m.historyIdx > 0 {
+					m.historyIdx--
+				}
+			case key.Matches(msg, m.keys.Down):
+				if m.historyIdx < len(note.Revisions)-1 {
+					m.historyIdx++
+				}
+			case key.Matches(msg, m.keys.Diff):
+				rev := revisionAt(note, m.historyIdx)
+				m.diffContent = renderDiff(utils.UnifiedDiff(rev.Content, note.Content, 3))
+				m.state = stateDiff
+			case key.Matches(msg, m.keys.RestoreVersion):
+				if !m.writable() {
+					break
+				}
+				m.restoreRevision(note, m.historyIdx)
+			case key.Matches(msg, m.keys.Back):
+				m.state = stateView
+			}
+		}
+		return m, nil
+
+	case stateDiff:
+		note, ok := m.nb.GetNote(m.current)
+		if !ok {
+			m.state = stateList
+			return m, nil
+		}
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, m.keys.ForceQuit):
+				return m, m.quit()
+			case key.Matches(msg, m.keys.RestoreVersion):
+				if !m.writable() {
+					break
+				}
+				m.restoreRevision(note, m.historyIdx)
+			case key.Matches(msg, m.keys.Back):
+				m.state = stateHistory
+			}
+		}
+		return m, nil
+	case stateEdit:
+		return m, m.updateEditor(msg)
+
+	case stateConflict:
+		return m, m.updateConflict(msg)
+
 	case stateChangePass:
 		var cmd tea.Cmd
 		m.pwInput, cmd = m.pwInput.Update(msg)
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.String() {
-			case "enter":
+			switch {
+			case key.Matches(msg, m.keys.Submit):
 				newpw := m.pwInput.Value()
 				if newpw == "" {
 					m.status = "Password not changed (empty)"
@@ -650,16 +762,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.status = "Password changed."
 				}
 
-				pi := textinput.New()
-				pi.Placeholder = "enter password"
-				pi.Focus()
-				pi.CharLimit = 64
-				pi.Width = 30
-				pi.EchoMode = textinput.EchoPassword
-				pi.EchoCharacter = '•'
-				m.pwInput = pi
+				m.pwInput = newPasswordInput("enter password")
 				m.state = stateList
-			case "esc":
+			case key.Matches(msg, m.keys.Cancel):
 				m.state = stateList
 			}
 		}
@@ -676,10 +781,19 @@ func (m Model) View() string {
 
 	switch m.state {
 	case statePass:
+		vaultLine := "Vault: " + titleStyle.Render(storage.CurrentVault())
+		if m.vaultNew {
+			vaultLine += helpStyle.Render(" (new)")
+		}
+		if len(m.vaults) > 1 {
+			vaultLine += helpStyle.Render(fmt.Sprintf("  [%d/%d]", m.vaultIdx+1, len(m.vaults)))
+		}
+		s.WriteString(vaultLine)
+		s.WriteString("\n\n")
 		s.WriteString("Enter password to unlock/create notebook:\n\n")
 		s.WriteString(m.pwInput.View())
 		s.WriteString("\n\n")
-		s.WriteString(helpStyle.Render("ctrl+c: quit"))
+		s.WriteString(m.helpView())
 		if m.status != "" {
 			s.WriteString("\n")
 			if m.lastError != "" {
@@ -689,31 +803,77 @@ func (m Model) View() string {
 			}
 		}
 
+	case stateLockConflict:
+		s.WriteString(warningStyle.Render(m.lockErr.Error()))
+		s.WriteString("\n\n")
+		s.WriteString("Changes made here would overwrite that session's, and the other way round.\n")
+		s.WriteString("Open read-only, or take over and stop the other session from saving?\n\n")
+		s.WriteString(m.helpView())
+
+	case stateNewVault:
+		s.WriteString("Name for the new vault:\n\n")
+		s.WriteString(m.vaultInput.View())
+		s.WriteString("\n\n")
+		s.WriteString(m.helpView())
+		if m.lastError != "" {
+			s.WriteString("\n")
+			s.WriteString(errorStyle.Render(m.status))
+		}
+
+	case stateRecoveryOffer:
+		s.WriteString("Create a recovery key?\n\n")
+		s.WriteString("A recovery key can unlock this notebook and set a new password\n")
+		s.WriteString("if you forget yours. Without one, a lost password means lost notes.\n\n")
+		s.WriteString(m.helpView())
+
+	case stateRecoveryShow:
+		s.WriteString("Your recovery key:\n\n")
+		s.WriteString(titleStyle.Render("  " + m.recoveryKey))
+		s.WriteString("\n\n")
+		s.WriteString(warningStyle.Render("Write this down and keep it somewhere safe. It will not be shown again."))
+		s.WriteString("\n\n")
+		s.WriteString(m.helpView())
+
+	case stateRecover:
+		if m.recoverKey == "" {
+			s.WriteString("Enter your recovery key:\n\n")
+			s.WriteString(m.recoverInput.View())
+		} else {
+			s.WriteString("Choose a new password:\n\n")
+			s.WriteString(m.pwInput.View())
+		}
+		s.WriteString("\n\n")
+		s.WriteString(m.helpView())
+
 	case stateSearch:
 		s.WriteString("Search notes:\n\n")
 		s.WriteString(m.searchInput.View())
 		s.WriteString("\n\n")
-		s.WriteString(helpStyle.Render("enter: search  esc: cancel"))
+		s.WriteString(m.helpView())
 
 	case stateConfirm:
 		s.WriteString(warningStyle.Render(m.confirmMsg))
 		s.WriteString("\n\n")
-		s.WriteString(helpStyle.Render("y: confirm  n/esc: cancel"))
+		s.WriteString(m.helpView())
 
 	case stateList:
 		s.WriteString(m.list.View())
 		s.WriteString("\n")
 
-		var helpParts []string
-		helpParts = append(helpParts, "a:add", "d:delete", "enter:view")
-		helpParts = append(helpParts, "/:search")
-		if m.searchTerm != "" {
-			helpParts = append(helpParts, "c:clear search")
+		if m.showTrash {
+			s.WriteString(m.helpView())
+			if m.status != "" {
+				s.WriteString("\n")
+				if m.lastError != "" {
+					s.WriteString(errorStyle.Render(m.status))
+				} else {
+					s.WriteString(successStyle.Render(m.status))
+				}
+			}
+			break
 		}
-		helpParts = append(helpParts, "s:sort", "e:export", "g:toggle archived", "P:change password", "q:quit")
 
-		helpParts = append(helpParts, "p:pin", "f:favorite", "t:tags")
-		s.WriteString(helpStyle.Render(strings.Join(helpParts, "  ")))
+		s.WriteString(m.helpView())
 
 		var statusParts []string
 		if m.sortBy == sortByTitle {
@@ -727,6 +887,9 @@ func (m Model) View() string {
 		if m.showArchived {
 			statusParts = append(statusParts, "viewing archived")
 		}
+		if m.dirty && !m.cfg.Autosave {
+			statusParts = append(statusParts, "unsaved changes")
+		}
 		if len(statusParts) > 0 {
 			s.WriteString("\n")
 			s.WriteString(helpStyle.Render(strings.Join(statusParts, " • ")))
@@ -745,13 +908,11 @@ func (m Model) View() string {
 		}
 
 	case stateView:
-		s.WriteString(titleStyle.Render(m.current))
+		s.WriteString(titleStyle.Render(m.currentTitle()))
 		s.WriteString("\n\n")
 		s.WriteString(m.viewContent)
 		s.WriteString("\n\n")
-		s.WriteString(helpStyle.Render("e:edit  d:delete  b:back  q:quit"))
-		s.WriteString("\n")
-		s.WriteString(helpStyle.Render("p:pin  f:favorite  t:tags  r:archive"))
+		s.WriteString(m.helpView())
 		if m.status != "" {
 			s.WriteString("\n")
 			if m.lastError != "" {
@@ -760,6 +921,51 @@ func (m Model) View() string {
 				s.WriteString(successStyle.Render(m.status))
 			}
 		}
+
+	case stateHistory:
+		note, ok := m.nb.GetNote(m.current)
+		if !ok {
+			break
+		}
+		s.WriteString(titleStyle.Render("History: " + note.Title))
+		s.WriteString("\n\n")
+		for i := range note.Revisions {
+			rev := revisionAt(note, i)
+			line := fmt.Sprintf("%s  %s", rev.UpdatedAt.Format("2006-01-02 15:04:05"), storage.ExtractTitle(rev.Content))
+			if rev.Device != "" {
+				line += "  (" + rev.Device + ")"
+			}
+			if i == m.historyIdx {
+				s.WriteString(titleStyle.Render("> " + line))
+			} else {
+				s.WriteString("  " + line)
+			}
+			s.WriteString("\n")
+		}
+		s.WriteString("\n")
+		s.WriteString(m.helpView())
+
+	case stateEdit:
+		s.WriteString(m.editorView())
+
+	case stateConflict:
+		s.WriteString(m.conflictView())
+		if m.status != "" {
+			s.WriteString("\n")
+			s.WriteString(successStyle.Render(m.status))
+		}
+
+	case stateDiff:
+		note, ok := m.nb.GetNote(m.current)
+		if !ok {
+			break
+		}
+		rev := revisionAt(note, m.historyIdx)
+		s.WriteString(titleStyle.Render(fmt.Sprintf("%s: %s → current", note.Title, rev.UpdatedAt.Format("2006-01-02 15:04"))))
+		s.WriteString("\n\n")
+		s.WriteString(m.diffContent)
+		s.WriteString("\n\n")
+		s.WriteString(m.helpView())
 	}
 
 	return s.String()
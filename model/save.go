@@ -0,0 +1,223 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/electr1fy0/blue/storage"
+)
+
+// savedMsg reports the result of a background save.
+type savedMsg struct {
+	saver *saver
+	err   error
+}
+
+// autosaveMsg fires when autosave_delay has passed since a change.
+type autosaveMsg struct {
+	saver *saver
+}
+
+// reloadedMsg carries the vault as it is on disk after an outside change,
+// along with the version this session last saw, to merge against.
+type reloadedMsg struct {
+	saver  *saver
+	base   *storage.Notebook
+	remote *storage.Notebook
+	err    error
+}
+
+// saver writes snapshots of the notebook in the background, one at a time,
+// so an older snapshot never lands after a newer one. It is shared by every
+// copy of the Model.
+type saver struct {
+	mu      sync.Mutex
+	vault   storage.Backend
+	base    *storage.Notebook // what is on disk, as of the last save or load
+	latest  *storage.Notebook // newest snapshot not yet written
+	pending int               // generation of latest
+	written int               // generation on disk
+	hold    bool              // reloaded; wait for the merge before writing
+	held    *storage.Notebook // base handed out with the reload
+	closed  bool
+}
+
+func newSaver(vault storage.Backend, nb *storage.Notebook) *saver {
+	return &saver{vault: vault, base: nb.Clone()}
+}
+
+// save records a snapshot of nb and returns the command that writes it.
+// Snapshots queued while a write is running are coalesced into one.
+func (s *saver) save(nb *storage.Notebook) tea.Cmd {
+	snap := nb.Clone()
+	s.mu.Lock()
+	s.pending++
+	s.latest = snap
+	s.mu.Unlock()
+	return func() tea.Msg {
+		err := s.flush()
+		return savedMsg{saver: s, err: err}
+	}
+}
+
+// flush writes the newest snapshot unless it is already on disk.
+func (s *saver) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+func (s *saver) flushLocked() error {
+	if s.closed || s.hold || s.latest == nil || s.pending == s.written {
+		return nil
+	}
+	gen := s.pending
+	if err := s.vault.Save(s.latest); err != nil {
+		return err
+	}
+	s.written = gen
+	s.base = s.latest
+	s.latest = nil
+	return nil
+}
+
+// reload returns the command that reads the vault after an outside change.
+// Writes are held until resume, since the pending snapshot predates the
+// merge.
+func (s *saver) reload() tea.Cmd {
+	return func() tea.Msg {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.closed {
+			return nil
+		}
+		remote, err := s.vault.Load()
+		if err != nil {
+			return reloadedMsg{saver: s, err: err}
+		}
+		s.held = s.base
+		s.base = remote.Clone()
+		s.latest = nil
+		s.written = s.pending
+		s.hold = true
+		return reloadedMsg{saver: s, base: s.held, remote: remote}
+	}
+}
+
+// resume lets writes continue once the reloaded notebook has been merged.
+func (s *saver) resume() {
+	s.mu.Lock()
+	s.hold = false
+	s.held = nil
+	s.mu.Unlock()
+}
+
+// close waits for any running save, writes what is still pending and wipes
+// the vault's key. It blocks, so it is only used when leaving the vault.
+func (s *saver) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	switch {
+	case s.hold && s.latest != nil:
+		// the UI never got to merge the reload
+		err = s.mergeLocked(s.held, s.base)
+	case s.hold:
+	default:
+		err = s.flushLocked()
+		if errors.Is(err, storage.ErrChangedOnDisk) {
+			var remote *storage.Notebook
+			if remote, err = s.vault.Load(); err == nil {
+				err = s.mergeLocked(s.base, remote)
+			}
+		}
+	}
+	s.closed = true
+	s.vault.Close()
+	return err
+}
+
+// mergeLocked merges the pending snapshot into remote and saves the
+// result, for when there is no time to do it in the UI.
+func (s *saver) mergeLocked(base, remote *storage.Notebook) error {
+	merged, _, changed := storage.MergeNotebooks(base, s.latest, remote)
+	if !changed {
+		return nil
+	}
+	return s.vault.Save(merged)
+}
+
+// persist marks the notebook as changed and sends the changes to the sync
+// server. Update starts the save once the message has been handled, so
+// several changes in one step save once.
+func (m *Model) persist() {
+	m.dirty = true
+	m.lastError = ""
+	m.pushChanges()
+}
+
+// scheduleSave returns the command that saves pending changes, if any,
+// as the autosave settings allow. Without autosave, changes wait for
+// ctrl+s or for the vault to be closed.
+func (m *Model) scheduleSave() tea.Cmd {
+	if !m.dirty || m.saver == nil || m.nb == nil || !m.cfg.Autosave {
+		return nil
+	}
+	if d := m.cfg.AutosaveDelay; d > 0 {
+		if m.saveDue {
+			return nil
+		}
+		m.saveDue = true
+		s := m.saver
+		return tea.Tick(d, func(time.Time) tea.Msg { return autosaveMsg{saver: s} })
+	}
+	return m.saveNow()
+}
+
+// saveNow starts saving pending changes right away.
+func (m *Model) saveNow() tea.Cmd {
+	m.saveDue = false
+	if !m.dirty || m.saver == nil || m.nb == nil || m.vault.ReadOnly() {
+		return nil
+	}
+	m.dirty = false
+	return m.saver.save(m.nb)
+}
+
+// stopSaving stops watching the vault, writes any pending changes and
+// closes the vault.
+func (m *Model) stopSaving() {
+	if m.watchStop != nil {
+		close(m.watchStop)
+		m.watchStop = nil
+		m.watchCh = nil
+	}
+	if m.saver == nil {
+		return
+	}
+	if m.nb != nil && !m.vault.ReadOnly() {
+		// even when not dirty: a reload may have dropped the last snapshot
+		m.saver.save(m.nb)
+		m.dirty = false
+	}
+	if err := m.saver.close(); err != nil {
+		m.status = "Failed to save: " + err.Error()
+		m.lastError = err.Error()
+	}
+	m.saver = nil
+	m.vault = nil
+	m.reloading = false
+	m.saveDue = false
+}
+
+// quit saves, closes the vault and exits.
+func (m *Model) quit() tea.Cmd {
+	if m.ws != nil {
+		_ = m.ws.Close()
+		m.ws = nil
+	}
+	m.stopSaving()
+	return tea.Quit
+}
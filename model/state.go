@@ -3,19 +3,14 @@ package model
 import (
 	"time"
 
+	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/electr1fy0/blue/config"
 	"github.com/electr1fy0/blue/storage"
 	"github.com/gorilla/websocket"
 )
 
-type noteMeta struct {
-	Tags     []string
-	Pinned   bool
-	Favorite bool
-	Archived bool
-}
-
 const (
 	statePass state = iota
 	stateList
@@ -24,6 +19,15 @@ const (
 	stateConfirm
 	stateQuit
 	stateChangePass
+	stateRecoveryOffer
+	stateRecoveryShow
+	stateRecover
+	stateHistory
+	stateDiff
+	stateNewVault
+	stateLockConflict
+	stateEdit
+	stateConflict
 )
 
 // sort options
@@ -35,31 +39,53 @@ const (
 )
 
 type listItem struct {
+	id        string
 	title     string
 	updatedAt time.Time
 	tags      []string
 	pinned    bool
 	favorited bool
 	archived  bool
+	deletedAt time.Time // set for notes shown in the trash
+	conflict  bool      // has a conflict copy, or is one
 }
 
 type state int
 
-type WSMessage struct {
-	Type     string        `json:"type"`
-	Note     *storage.Note `json:"note,omitempty"`
-	OldTitle string        `json:"old_title,omitempty"`
-}
-
 type Model struct {
+	cfg         config.Config
+	keys        KeyMap
+	help        help.Model
+	themes      []Theme
+	themeIdx    int
 	state       state
 	renderCache map[string]string
 
 	width  int
 	height int
 
-	pwInput  textinput.Model
-	password string
+	pwInput textinput.Model
+	vault   storage.Backend // the unlocked vault; nil while locked
+	saver   *saver          // writes vault in the background
+	dirty   bool            // nb changed since the last scheduled save
+	saveDue bool            // an autosave_delay timer is running
+
+	lastActivity time.Time    // last key or mouse input, for auto-lock
+	resume       *resumePoint // where to go back to after unlocking
+
+	watchStop chan struct{}   // closed to stop watching vault
+	watchCh   <-chan struct{} // signals outside changes to vault
+	reloading bool
+
+	vaults     []string // names offered on the password screen
+	vaultIdx   int
+	vaultNew   bool // the selected vault doesn't exist yet
+	vaultInput textinput.Model
+	lockErr    *storage.LockedError // set in stateLockConflict
+
+	recoveryKey  string // freshly generated key, shown once
+	recoverInput textinput.Model
+	recoverKey   string // key entered on the recovery screen
 
 	nb *storage.Notebook
 
@@ -70,17 +96,29 @@ type Model struct {
 	searchTerm  string
 	allItems    []list.Item
 
-	current     string
+	current     string // ID of the note open in stateView
 	viewContent string
 
+	editor *editor // the built-in editor, open in stateEdit
+
+	conflict *conflict // open in stateConflict
+
+	historyIdx  int // selected revision, 0 is the newest
+	diffContent string
+
 	confirmMsg    string
-	confirmAction func()
+	confirmAction func(*Model)
 
 	status    string
 	lastError string
 
-	ws       *websocket.Conn
-	wsStatus string
+	ws        *websocket.Conn
+	wsStatus  string
+	syncURL   string            // server ws is connected to
+	syncReady bool              // handshake done; changes may be sent
+	pending   map[string]string // note ID → digest sent, or deletedDigest
+	sent      int               // changes sent on this connection
 
 	showArchived bool
+	showTrash    bool
 }
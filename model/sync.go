@@ -0,0 +1,274 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/electr1fy0/blue/crypto"
+	"github.com/electr1fy0/blue/server"
+	"github.com/electr1fy0/blue/storage"
+)
+
+// deletedDigest marks a deletion in Model.pending.
+const deletedDigest = "deleted"
+
+// sayHello starts the handshake on a new sync connection, asking for the
+// changes since the last one the vault saw.
+func (m *Model) sayHello() {
+	st := m.nb.SyncFor(m.syncURL)
+	m.writeWS(server.Envelope{Type: server.MsgHello, Protocol: server.ProtocolVersion, Since: st.Seq})
+}
+
+// applyWsMessage merges a message from the sync server into the notebook.
+func (m *Model) applyWsMessage(data []byte) {
+	var e server.Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return
+	}
+	st := m.nb.SyncFor(m.syncURL)
+
+	switch e.Type {
+	case server.MsgHello:
+		if e.Protocol != server.ProtocolVersion {
+			m.dropSync(fmt.Errorf("sync server speaks protocol %d, this blue %d", e.Protocol, server.ProtocolVersion))
+		}
+	case server.MsgError:
+		m.dropSync(errors.New("sync server: " + e.Error))
+	case server.MsgChanges:
+		if e.Full {
+			// the server starts from scratch; what we knew of it is void
+			st.Seq, st.Notes = 0, make(map[string]storage.SyncedNote)
+		}
+		got, unreadable := 0, 0
+		for _, c := range e.Notes {
+			status, err := m.applyChange(st, c)
+			if err != nil {
+				unreadable++
+			} else if status != "" {
+				got++
+			}
+			st.Saw(c.Seq)
+		}
+		st.Saw(e.Seq)
+		m.syncReady = true
+		m.persist()
+		m.refreshList()
+		m.status = fmt.Sprintf("Synced: %d changes from server, %d sent", got, m.sent)
+		if unreadable > 0 {
+			// most likely another device with its own sync key
+			m.lastError = fmt.Sprintf("%d notes on the server use a different sync key", unreadable)
+			m.status += "; " + m.lastError + " (see blue vault sync-key)"
+		}
+	case server.MsgConflict:
+		delete(m.pending, e.ID)
+		if st.Notes[e.ID].Version != e.Version {
+			// we never heard of the server's version, so sending again
+			// would be refused again
+			m.lastError = "sync server refused a change to a note it didn't send"
+			m.status = "Sync: " + m.lastError + "; reconnect to resync"
+			return
+		}
+		// the change it refused has been merged with the one we missed;
+		// send the result
+		m.pushChanges()
+	case server.MsgPut, server.MsgDelete:
+		status, err := m.applyChange(st, e)
+		st.Saw(e.Seq)
+		m.persist()
+		switch {
+		case err != nil:
+			m.lastError = err.Error()
+			m.status = "Remote note skipped: " + err.Error()
+		case status != "":
+			m.refreshList()
+			m.status = status
+		}
+	}
+}
+
+// applyChange applies one change from the server. It returns what to tell
+// the user about it, or "" if nothing changed here, e.g. for the server
+// confirming a change of our own.
+func (m *Model) applyChange(st *storage.SyncState, e server.Envelope) (string, error) {
+	known, wasKnown := st.Notes[e.ID]
+	local, live := m.nb.GetNote(e.ID)
+	// edited here since the server last had it
+	changed := live && (!wasKnown || storage.NoteDigest(local) != known.Digest)
+
+	if e.Type == server.MsgDelete {
+		st.RecordDeleted(e.ID, e.Version)
+		if m.pending[e.ID] == deletedDigest {
+			delete(m.pending, e.ID)
+		}
+		if !live || changed {
+			// an edit made here survives, and is sent back
+			return "", nil
+		}
+		m.nb.DeleteNote(e.ID)
+		return "Remote delete: " + local.Title, nil
+	}
+
+	if wasKnown && e.Version <= known.Version {
+		return "", nil
+	}
+	nn, err := storage.OpenNote(e.ID, e.Blob, m.nb.SyncKey)
+	if err != nil {
+		// known by version only, so that a note of ours with this ID,
+		// e.g. after the sync key changed, is sent to replace it
+		st.RecordDeleted(e.ID, e.Version)
+		return "", err
+	}
+	digest := storage.NoteDigest(nn)
+	if m.pending[e.ID] == digest {
+		delete(m.pending, e.ID)
+	}
+	st.Record(nn, e.Version)
+
+	if live && storage.NoteDigest(local) == digest {
+		return "", nil
+	}
+	if changed {
+		// edited on both sides: whatever is kept here goes to the server
+		// next, made from the version just recorded
+		keep, dup := storage.MergeRemote(known.Base, local, nn)
+		m.nb.Notes[e.ID] = keep
+		switch {
+		case dup != nil:
+			m.nb.Notes[dup.ID] = dup
+			return fmt.Sprintf("Conflict in %s: both versions kept; %s to resolve", local.Title, m.keys.Resolve.Help().Key), nil
+		case keep == local:
+			return "", nil
+		case keep != nn:
+			return "Merged remote edit: " + keep.Title, nil
+		}
+	}
+	// restored or edited elsewhere; drop our trashed copy
+	m.nb.PurgeNote(nn.ID)
+	m.nb.Notes[nn.ID] = nn
+	if live {
+		return "Remote edit: " + nn.Title, nil
+	}
+	return "Remote add: " + nn.Title, nil
+}
+
+// pushChanges sends the sync server every note changed since it last had
+// it, and deletes the notes that are gone here, once the handshake is
+// done. Changes sent and not yet confirmed are in m.pending, so they
+// aren't sent twice.
+func (m *Model) pushChanges() {
+	if m.ws == nil || !m.syncReady || m.nb == nil {
+		return
+	}
+	st := m.nb.SyncFor(m.syncURL)
+	for id, n := range m.nb.Notes {
+		d := storage.NoteDigest(n)
+		if st.Notes[id].Digest == d || m.pending[id] == d {
+			continue
+		}
+		blob, err := storage.SealNote(n, m.nb.SyncKey)
+		if err != nil {
+			m.lastError = err.Error()
+			m.status = "Sync: " + err.Error()
+			return
+		}
+		if !m.writeWS(server.Envelope{Type: server.MsgPut, ID: id, Blob: blob, Base: st.Notes[id].Version}) {
+			return
+		}
+		m.pending[id] = d
+		m.sent++
+	}
+	for id, sn := range st.Notes {
+		if _, ok := m.nb.Notes[id]; ok || sn.Digest == "" || m.pending[id] == deletedDigest {
+			continue
+		}
+		if !m.writeWS(server.Envelope{Type: server.MsgDelete, ID: id, Base: sn.Version}) {
+			return
+		}
+		m.pending[id] = deletedDigest
+		m.sent++
+	}
+}
+
+// writeWS writes a message to the sync server, dropping the connection on
+// failure.
+func (m *Model) writeWS(e server.Envelope) bool {
+	if err := m.ws.WriteJSON(e); err != nil {
+		m.dropSync(fmt.Errorf("WS write failed: %w", err))
+		return false
+	}
+	return true
+}
+
+// dropSync closes the sync connection because of err.
+func (m *Model) dropSync(err error) {
+	if m.ws != nil {
+		_ = m.ws.Close()
+	}
+	m.resetSync()
+	m.lastError = err.Error()
+	m.status = err.Error()
+}
+
+// resetSync forgets the sync connection and the changes in flight on it.
+func (m *Model) resetSync() {
+	m.ws = nil
+	m.wsStatus = "disconnected"
+	m.syncReady = false
+	m.pending = nil
+	m.sent = 0
+}
+
+// connectSync starts a connection to the vault's sync server, if enabled.
+func (m *Model) connectSync() tea.Cmd {
+	vs, err := storage.LoadVaultSettings(storage.CurrentVault())
+	if err != nil {
+		m.status = "Sync settings: " + err.Error()
+		m.lastError = err.Error()
+		return nil
+	}
+	if vs.SyncDisabled {
+		m.wsStatus = "off"
+		return nil
+	}
+	if m.vault.ReadOnly() {
+		// remote changes couldn't be saved
+		m.wsStatus = "off (read-only)"
+		return nil
+	}
+	if made, err := m.nb.EnsureSyncKey(); err != nil {
+		m.status = "Sync key: " + err.Error()
+		m.lastError = err.Error()
+		return nil
+	} else if made {
+		m.persist()
+	}
+	url, token := vs.SyncURL, vs.SyncToken
+	if url == "" {
+		url = m.cfg.SyncURL
+	}
+	if token == "" {
+		token = m.cfg.SyncToken
+	}
+	m.syncURL = url
+	m.wsStatus = "connecting"
+	return server.Connect(url, token)
+}
+
+// closeVault drops the sync connection, saves pending changes and wipes the
+// unlocked vault from memory.
+func (m *Model) closeVault() {
+	if m.ws != nil {
+		_ = m.ws.Close()
+	}
+	m.resetSync()
+	m.stopSaving()
+	if m.nb != nil {
+		crypto.Wipe(m.nb.SyncKey)
+	}
+	m.nb = nil
+	m.current = ""
+	m.viewContent = ""
+	m.list.SetItems(nil)
+}
@@ -0,0 +1,160 @@
+package model
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/electr1fy0/blue/config"
+	"github.com/electr1fy0/blue/server"
+	"github.com/electr1fy0/blue/storage"
+	"github.com/gorilla/websocket"
+)
+
+// fakeHub answers a client's hello with the given changes, then passes
+// on what the client sends and sends it replies.
+type fakeHub struct {
+	url      string
+	received chan server.Envelope
+	reply    chan server.Envelope
+}
+
+func newFakeHub(t *testing.T, changes server.Envelope) *fakeHub {
+	t.Helper()
+	h := &fakeHub{received: make(chan server.Envelope, 16), reply: make(chan server.Envelope)}
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var hello server.Envelope
+		if conn.ReadJSON(&hello) != nil {
+			return
+		}
+		h.received <- hello
+		conn.WriteJSON(server.Envelope{Type: server.MsgHello, Protocol: server.ProtocolVersion, Seq: changes.Seq})
+		conn.WriteJSON(changes)
+		go func() {
+			for e := range h.reply {
+				conn.WriteJSON(e)
+			}
+		}()
+		for {
+			var e server.Envelope
+			if conn.ReadJSON(&e) != nil {
+				return
+			}
+			h.received <- e
+		}
+	}))
+	t.Cleanup(func() {
+		srv.CloseClientConnections()
+		srv.Close()
+	})
+	h.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return h
+}
+
+// next returns the next message from the client, or fails if there is
+// none.
+func (h *fakeHub) next(t *testing.T) server.Envelope {
+	t.Helper()
+	select {
+	case e := <-h.received:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("client sent nothing")
+		return server.Envelope{}
+	}
+}
+
+func (h *fakeHub) quiet(t *testing.T) {
+	t.Helper()
+	select {
+	case e := <-h.received:
+		t.Fatalf("client sent %+v", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// syncModel returns a model with an unlocked notebook, connected to url.
+func syncModel(t *testing.T, url string, notes ...*storage.Note) Model {
+	t.Helper()
+	m, err := InitialModel(config.Config{Theme: "dark", Keymap: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nb = storage.NewNotebook()
+	if _, err := m.nb.EnsureSyncKey(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range notes {
+		m.nb.AddNote(n)
+	}
+	m.syncURL = url
+	msg := server.Connect(url, "")()
+	m = update(m, msg)
+	if m.ws == nil {
+		t.Fatalf("not connected: %+v", msg)
+	}
+	t.Cleanup(func() { m.ws.Close() })
+	return m
+}
+
+func update(m Model, msg any) Model {
+	nm, _ := m.Update(msg)
+	return nm.(Model)
+}
+
+// receive hands the model the next n messages from the server.
+func receive(t *testing.T, m Model, n int) Model {
+	t.Helper()
+	for range n {
+		m.ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := m.ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m = update(m, server.WsMessage{Conn: m.ws, Data: data})
+	}
+	return m
+}
+
+// A note the server has deleted is sent again from the deletion, rather
+// than from nothing, which the server would refuse for ever.
+func TestSyncFullResyncOfDeletedNote(t *testing.T) {
+	hub := newFakeHub(t, server.Envelope{Type: server.MsgChanges, Full: true, Seq: 2, Notes: []server.Envelope{
+		{Type: server.MsgDelete, ID: "x", Version: 2, Seq: 2},
+	}})
+	m := syncModel(t, hub.url, &storage.Note{ID: "x", Content: "# kept"})
+
+	if e := hub.next(t); e.Type != server.MsgHello || e.Since != 0 {
+		t.Fatalf("got %+v, want hello since 0", e)
+	}
+	m = receive(t, m, 2)
+	e := hub.next(t)
+	if e.Type != server.MsgPut || e.ID != "x" || e.Base != 2 {
+		t.Fatalf("got %+v, want x sent from version 2", e)
+	}
+	if _, ok := m.nb.GetNote("x"); !ok {
+		t.Fatal("the deletion removed a note edited here")
+	}
+
+	// a refusal from a version we know of is answered with the note again
+	hub.reply <- server.Envelope{Type: server.MsgConflict, ID: "x", Version: 2}
+	m = receive(t, m, 1)
+	if e := hub.next(t); e.Type != server.MsgPut || e.Base != 2 {
+		t.Fatalf("got %+v, want x sent again", e)
+	}
+	// one from a version we never saw isn't, or it would never end
+	hub.reply <- server.Envelope{Type: server.MsgConflict, ID: "x", Version: 7}
+	m = receive(t, m, 1)
+	hub.quiet(t)
+	if m.lastError == "" {
+		t.Error("the refusal wasn't reported")
+	}
+}
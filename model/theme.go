@@ -0,0 +1,277 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/electr1fy0/blue/config"
+	"github.com/electr1fy0/blue/utils"
+)
+
+// Theme sets the colors of the UI and the markdown style of notes together.
+// Colors are ANSI numbers ("12") or hex ("#268bd2"); an empty color keeps
+// the terminal's default.
+type Theme struct {
+	Name    string
+	Title   string
+	Muted   string // help text and other secondary text
+	Error   string
+	Success string
+	Warning string
+	Accent  string // selected note
+	Bar     string // background of the list title
+	BarText string
+
+	// Glamour is the glamour style JSON notes are rendered with.
+	Glamour []byte
+	// glow is the style passed to glow: a built-in name, or "" to write
+	// Glamour to a file for it.
+	glow string
+}
+
+// themeFields are the settings a user-defined theme may set, as
+// theme.<name>.<field> in the config.
+var themeFields = []string{"base", "title", "muted", "error", "success", "warning", "accent", "bar", "bar_text", "glamour"}
+
+// builtinThemes lists the themes blue ships with, in the order ctrl+t
+// cycles through them.
+func builtinThemes() []Theme {
+	return []Theme{
+		{
+			Name: "dark", Title: "12", Error: "9", Success: "10", Warning: "11",
+			Accent: "#EE6FF8", Bar: "62", BarText: "230",
+			Glamour: styleJSON(styles.DarkStyleConfig), glow: "dark",
+		},
+		{
+			Name: "light", Title: "4", Muted: "245", Error: "1", Success: "2", Warning: "130",
+			Accent: "#C238B6", Bar: "62", BarText: "230",
+			Glamour: styleJSON(styles.LightStyleConfig), glow: "light",
+		},
+		{
+			Name: "high-contrast", Title: "15", Muted: "252", Error: "9", Success: "10", Warning: "11",
+			Accent: "11", Bar: "15", BarText: "0",
+			Glamour: styleJSON(restyle(styles.DarkStyleConfig, markdownColors{
+				text: "15", heading: "11", h1: "0", h1Bg: "11", code: "15", codeBg: "0", link: "14", quote: "15",
+			})),
+		},
+		{
+			Name: "solarized", Title: "#268bd2", Muted: "#586e75", Error: "#dc322f", Success: "#859900", Warning: "#b58900",
+			Accent: "#d33682", Bar: "#073642", BarText: "#93a1a1",
+			Glamour: styleJSON(restyle(styles.DarkStyleConfig, markdownColors{
+				text: "#839496", heading: "#268bd2", h1: "#fdf6e3", h1Bg: "#268bd2", code: "#2aa198", codeBg: "#073642", link: "#6c71c4", quote: "#93a1a1",
+			})),
+		},
+	}
+}
+
+func styleJSON(c ansi.StyleConfig) []byte {
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// markdownColors are the colors restyle changes in a glamour style.
+type markdownColors struct {
+	text, heading, h1, h1Bg, code, codeBg, link, quote string
+}
+
+// restyle returns base with its main colors replaced.
+func restyle(base ansi.StyleConfig, c markdownColors) ansi.StyleConfig {
+	s := base
+	str := func(v string) *string { return &v }
+	s.Document.Color = str(c.text)
+	s.Heading.Color = str(c.heading)
+	s.H1.Color = str(c.h1)
+	s.H1.BackgroundColor = str(c.h1Bg)
+	s.Code.Color = str(c.code)
+	s.Code.BackgroundColor = str(c.codeBg)
+	s.CodeBlock.Color = str(c.code)
+	s.Link.Color = str(c.link)
+	s.LinkText.Color = str(c.link)
+	s.BlockQuote.Color = str(c.quote)
+	s.Item.Color = str(c.text)
+	s.Enumeration.Color = str(c.heading)
+	return s
+}
+
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func checkColor(v string) error {
+	if v == "" || colorPattern.MatchString(v) {
+		return nil
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 255 {
+		return nil
+	}
+	return fmt.Errorf("%q is not a color like 12 or #268bd2", v)
+}
+
+// loadThemes returns the built-in themes followed by those defined in cfg,
+// in name order.
+func loadThemes(cfg config.Config) ([]Theme, error) {
+	themes := builtinThemes()
+	names := make([]string, 0, len(cfg.Themes))
+	for name := range cfg.Themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fields := cfg.Themes[name]
+		for f := range fields {
+			if !slices.Contains(themeFields, f) {
+				return nil, fmt.Errorf("theme.%s.%s: unknown field; use one of %v", name, f, themeFields)
+			}
+		}
+		// start from the theme it is based on, dark by default
+		base := "dark"
+		if b, ok := fields["base"]; ok {
+			base = b
+		}
+		i := slices.IndexFunc(themes, func(t Theme) bool { return t.Name == base })
+		if i < 0 {
+			return nil, fmt.Errorf("theme.%s.base: no theme %q", name, base)
+		}
+		t := themes[i]
+		t.Name = name
+		for f, dst := range map[string]*string{
+			"title": &t.Title, "muted": &t.Muted, "error": &t.Error, "success": &t.Success,
+			"warning": &t.Warning, "accent": &t.Accent, "bar": &t.Bar, "bar_text": &t.BarText,
+		} {
+			v, ok := fields[f]
+			if !ok {
+				continue
+			}
+			if err := checkColor(v); err != nil {
+				return nil, fmt.Errorf("theme.%s.%s: %w", name, f, err)
+			}
+			*dst = v
+		}
+		if path, ok := fields["glamour"]; ok {
+			style, glow, err := loadGlamourStyle(path)
+			if err != nil {
+				return nil, fmt.Errorf("theme.%s.glamour: %w", name, err)
+			}
+			t.Glamour, t.glow = style, glow
+		}
+		if i := slices.IndexFunc(themes, func(t Theme) bool { return t.Name == name }); i >= 0 {
+			themes[i] = t
+		} else {
+			themes = append(themes, t)
+		}
+	}
+	return themes, nil
+}
+
+// loadGlamourStyle reads a glamour style: one of glamour's built-in names,
+// or a JSON file.
+func loadGlamourStyle(path string) ([]byte, string, error) {
+	if c, ok := styles.DefaultStyles[path]; ok {
+		return styleJSON(*c), path, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var c ansi.StyleConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return data, path, nil
+}
+
+// findTheme returns the index of the theme called name.
+func findTheme(themes []Theme, name string) (int, error) {
+	i := slices.IndexFunc(themes, func(t Theme) bool { return t.Name == name })
+	if i < 0 {
+		names := make([]string, len(themes))
+		for j, t := range themes {
+			names[j] = t.Name
+		}
+		return 0, fmt.Errorf("theme: no theme %q; have %v", name, names)
+	}
+	return i, nil
+}
+
+func color(v string) lipgloss.TerminalColor {
+	if v == "" {
+		return lipgloss.NoColor{}
+	}
+	return lipgloss.Color(v)
+}
+
+// apply sets the package styles and the list and help styles from t.
+func (t Theme) apply(l *list.Model, h *help.Model) {
+	titleStyle = lipgloss.NewStyle().Bold(true).Foreground(color(t.Title))
+	helpStyle = lipgloss.NewStyle().Faint(true).Foreground(color(t.Muted))
+	errorStyle = lipgloss.NewStyle().Foreground(color(t.Error))
+	successStyle = lipgloss.NewStyle().Foreground(color(t.Success))
+	warningStyle = lipgloss.NewStyle().Foreground(color(t.Warning))
+
+	d := list.NewDefaultDelegate()
+	d.Styles.SelectedTitle = d.Styles.SelectedTitle.Foreground(color(t.Accent)).BorderForeground(color(t.Accent))
+	d.Styles.SelectedDesc = d.Styles.SelectedDesc.Foreground(color(t.Accent)).BorderForeground(color(t.Accent)).Faint(true)
+	l.SetDelegate(d)
+	l.Styles.Title = l.Styles.Title.Background(color(t.Bar)).Foreground(color(t.BarText))
+
+	h.Styles = newHelp().Styles
+	if t.Muted != "" {
+		h.Styles.ShortKey = h.Styles.ShortKey.Foreground(color(t.Muted)).Bold(true)
+		h.Styles.FullKey = h.Styles.FullKey.Foreground(color(t.Muted)).Bold(true)
+		h.Styles.ShortDesc = h.Styles.ShortDesc.Foreground(color(t.Muted))
+		h.Styles.FullDesc = h.Styles.FullDesc.Foreground(color(t.Muted))
+	}
+}
+
+// glowStyle returns what to pass to glow -s for t, writing its style to
+// the cache directory if glow doesn't know it by name.
+func (t Theme) glowStyle() (string, error) {
+	if t.glow != "" {
+		return t.glow, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "blue", "styles")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, t.Name+".json")
+	if err := os.WriteFile(path, t.Glamour, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// setTheme switches to themes[i] and re-renders the open note.
+func (m *Model) setTheme(i int) {
+	m.themeIdx = i
+	m.themes[i].apply(&m.list, &m.help)
+	m.renderCache = nil
+	if m.nb == nil || m.current == "" {
+		return
+	}
+	note, ok := m.nb.GetNote(m.current)
+	if !ok {
+		return
+	}
+	m.viewContent = m.renderNote(note.Content)
+	if m.state == stateDiff {
+		rev := revisionAt(note, m.historyIdx)
+		m.diffContent = renderDiff(utils.UnifiedDiff(rev.Content, note.Content, 3))
+	}
+}
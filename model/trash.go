@@ -0,0 +1,70 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// updateTrash handles keys in stateList while the trash is shown.
+func (m Model) updateTrash(msg tea.Msg, cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	km, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, cmd
+	}
+
+	switch {
+	case key.Matches(km, m.keys.Quit, m.keys.ForceQuit):
+		return m, m.quit()
+	case key.Matches(km, m.keys.Trash, m.keys.Back):
+		m.showTrash = false
+		m.status = "Showing notes"
+		m.refreshList()
+	case key.Matches(km, m.keys.Restore):
+		if !m.writable() {
+			break
+		}
+		if it := m.list.SelectedItem(); it != nil {
+			item := it.(listItem)
+			if note, ok := m.nb.RestoreNote(item.id); ok {
+				m.persist()
+				m.refreshList()
+				m.status = "Restored: " + note.Title
+			}
+		}
+	case key.Matches(km, m.keys.Purge):
+		if !m.writable() {
+			break
+		}
+		if it := m.list.SelectedItem(); it != nil {
+			item := it.(listItem)
+			m.confirmMsg = fmt.Sprintf("Permanently delete '%s'? This cannot be undone. (y/N)", item.title)
+			m.confirmAction = func(m *Model) {
+				if m.nb.PurgeNote(item.id) {
+					m.persist()
+					m.refreshList()
+					m.status = "Deleted forever: " + item.title
+				}
+			}
+			m.state = stateConfirm
+		}
+	case key.Matches(km, m.keys.EmptyTrash):
+		if !m.writable() {
+			break
+		}
+		if len(m.nb.Trash) == 0 {
+			m.status = "Trash is empty"
+			break
+		}
+		m.confirmMsg = fmt.Sprintf("Permanently delete all %d notes in the trash? (y/N)", len(m.nb.Trash))
+		m.confirmAction = func(m *Model) {
+			n := m.nb.EmptyTrash()
+			m.persist()
+			m.refreshList()
+			m.status = fmt.Sprintf("Emptied trash (%d notes)", n)
+		}
+		m.state = stateConfirm
+	}
+	return m, cmd
+}
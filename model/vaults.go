@@ -0,0 +1,107 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/electr1fy0/blue/storage"
+)
+
+// loadVaults refreshes the vault picker, keeping the active vault selected
+// even if it hasn't been created yet.
+func (m *Model) loadVaults() {
+	names, err := storage.ListVaults()
+	if err != nil {
+		m.status = "Failed to list vaults: " + err.Error()
+		m.lastError = err.Error()
+	}
+	cur := storage.CurrentVault()
+	if !slices.Contains(names, cur) {
+		names = append(names, cur)
+		slices.Sort(names)
+	}
+	m.vaults = names
+	m.vaultIdx = slices.Index(names, cur)
+	m.checkVaultNew()
+}
+
+// checkVaultNew notes whether the selected vault is yet to be created, for
+// the password screen, which shouldn't touch the disk on every render.
+func (m *Model) checkVaultNew() {
+	exists, err := storage.NotebookExists()
+	m.vaultNew = err == nil && !exists
+}
+
+func (m *Model) selectVault(i int) {
+	if err := storage.SetVault(m.vaults[i]); err != nil {
+		m.status = err.Error()
+		m.lastError = err.Error()
+		return
+	}
+	m.vaultIdx = i
+	m.checkVaultNew()
+	m.pwInput.SetValue("")
+	m.status = ""
+	m.lastError = ""
+}
+
+// openVault unlocks the active vault with the password that was entered.
+// If another session holds it, the user is asked what to do instead.
+func (m *Model) openVault(mode storage.OpenMode) tea.Cmd {
+	vault, nb, err := storage.OpenVault(m.pwInput.Value(), mode)
+	var locked *storage.LockedError
+	if errors.As(err, &locked) {
+		m.lockErr = locked
+		m.state = stateLockConflict
+		return nil
+	}
+	m.lockErr = nil
+	if err != nil {
+		m.status = "Failed to decrypt: " + err.Error()
+		m.lastError = err.Error()
+		m.pwInput.SetValue("")
+		m.state = statePass
+		return nil
+	}
+	sync := m.unlocked(vault, nb)
+	m.state = stateList
+	m.status = fmt.Sprintf("Loaded notebook (%d notes)", len(m.nb.Notes))
+	if vault.ReadOnly() {
+		m.status += " read-only"
+	}
+	m.resumeView()
+	return sync
+}
+
+// writable reports whether changes can be saved, and says why not in the
+// status line.
+func (m *Model) writable() bool {
+	if m.vault == nil || !m.vault.ReadOnly() {
+		return true
+	}
+	m.status = "Vault is read-only; changes can't be saved"
+	m.lastError = m.status
+	return false
+}
+
+// unlocked installs a freshly opened vault and returns the command that
+// connects it to its sync server.
+func (m *Model) unlocked(vault storage.Backend, nb *storage.Notebook) tea.Cmd {
+	m.vault = vault
+	m.saver = newSaver(vault, nb)
+	m.nb = nb
+	m.pwInput.SetValue("")
+	m.lastError = ""
+	m.list.Title = "Notes · " + storage.CurrentVault()
+	if vault.ReadOnly() {
+		m.list.Title += " (read-only)"
+	}
+	m.refreshList()
+	m.watchStop = make(chan struct{})
+	m.watchCh = vault.Watch(m.watchStop)
+	m.lastActivity = time.Now()
+	return tea.Batch(m.connectSync(), waitForChange(m.watchCh), m.idleTimer(m.cfg.AutoLock))
+}
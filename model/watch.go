@@ -0,0 +1,87 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/electr1fy0/blue/storage"
+)
+
+// vaultChangedMsg is sent when something else changed the open vault.
+type vaultChangedMsg struct {
+	ch <-chan struct{}
+}
+
+// waitForChange waits for the next signal from Backend.Watch.
+func waitForChange(ch <-chan struct{}) tea.Cmd {
+	return func() tea.Msg {
+		if _, ok := <-ch; !ok {
+			return nil
+		}
+		return vaultChangedMsg{ch: ch}
+	}
+}
+
+// reload starts reading the vault back in, unless that is already under
+// way.
+func (m *Model) reload() tea.Cmd {
+	if m.reloading || m.saver == nil {
+		return nil
+	}
+	m.reloading = true
+	return m.saver.reload()
+}
+
+// applyReload merges the notebook read from disk with the changes made
+// here since, and saves the result if it differs from what is on disk.
+func (m *Model) applyReload(msg reloadedMsg) {
+	m.reloading = false
+	if msg.err != nil {
+		m.status = "Vault changed on disk but could not be read: " + msg.err.Error()
+		m.lastError = msg.err.Error()
+		return
+	}
+
+	merged, conflicts, changed := storage.MergeNotebooks(msg.base, m.nb, msg.remote)
+	m.nb = merged
+	m.saver.resume()
+	if changed {
+		m.persist()
+	}
+	m.refreshList()
+
+	switch m.state {
+	case stateView, stateHistory, stateDiff:
+		note, ok := m.nb.GetNote(m.current)
+		if !ok {
+			m.state = stateList
+			m.status = "The open note was removed on disk"
+			return
+		}
+		m.viewContent = m.renderNote(note.Content)
+		if m.state == stateHistory || m.state == stateDiff {
+			m.historyIdx = min(m.historyIdx, max(len(note.Revisions)-1, 0))
+			if len(note.Revisions) == 0 {
+				m.state = stateView
+			}
+		}
+	}
+
+	if conflicts > 0 {
+		m.status = fmt.Sprintf("Reloaded vault changed on disk; %d conflicting notes kept as copies", conflicts)
+	} else {
+		m.status = "Reloaded vault changed on disk"
+	}
+}
+
+// handleSaveError reacts to a failed background save. A vault changed on
+// disk is reloaded and merged rather than reported.
+func (m *Model) handleSaveError(err error) tea.Cmd {
+	if errors.Is(err, storage.ErrChangedOnDisk) {
+		return m.reload()
+	}
+	m.status = "Failed to save: " + err.Error()
+	m.lastError = err.Error()
+	return nil
+}
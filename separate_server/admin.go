@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// tokenCommand creates, lists and revokes the tokens clients log in with.
+// A running server notices the changes within a few seconds.
+func tokenCommand(usersPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: token create|list|revoke")
+	}
+	users, err := loadUsers(usersPath)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: token create <user>")
+		}
+		token, rec, err := users.create(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Created token %s for %s. It is shown only once:\n", rec.ID, rec.User)
+		fmt.Println(token)
+		return nil
+	case "list", "ls":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tCREATED")
+		for _, t := range users.list() {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", t.ID, t.User, t.Created.Local().Format("2006-01-02 15:04"))
+		}
+		return w.Flush()
+	case "revoke", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: token revoke <id|token>")
+		}
+		rec, err := users.revoke(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked token %s of %s.\n", rec.ID, rec.User)
+		return nil
+	default:
+		return fmt.Errorf("unknown token command %q", args[0])
+	}
+}
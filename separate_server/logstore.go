@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/electr1fy0/blue/server"
+)
+
+// A logStore directory holds a snapshot of every user's notes and a log of
+// the changes made since, both numbered by generation:
+//
+//	snapshot        {"gen": 3, "users": {...}}
+//	log.3           one record per line
+//
+// Every change is appended to the log and synced before it is relayed.
+// Once the log has snapshotEvery records the state is written to a new
+// snapshot with the next generation, and the log starts over. A crash at
+// any point leaves either the old snapshot and its log or the new snapshot,
+// and a record cut short by a crash is dropped on the next start.
+const (
+	snapshotFile  = "snapshot"
+	logPrefix     = "log."
+	snapshotEvery = 1000
+)
+
+// logRecord is one change in the log. On disk it is the CRC-32 of the JSON
+// in hex, a space and the JSON, on one line.
+type logRecord struct {
+	User string          `json:"user"`
+	Note server.Envelope `json:"note"`
+}
+
+type snapshot struct {
+	Gen   int                                   `json:"gen"`
+	Users map[string]map[string]server.Envelope `json:"users"`
+}
+
+// logStore is the default Store: an append-only log with snapshots.
+type logStore struct {
+	dir string
+
+	mu      sync.Mutex
+	state   snapshot
+	log     *os.File
+	records int // in the current log
+}
+
+// openLogStore opens the store in dir, creating it if needed, and recovers
+// the state the last run left.
+func openLogStore(dir string) (*logStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &logStore{dir: dir}
+	if err := s.readSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	s.removeStaleLogs()
+	return s, nil
+}
+
+func (s *logStore) readSnapshot() error {
+	s.state = snapshot{Users: make(map[string]map[string]server.Envelope)}
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// snapshots are renamed into place whole, so a bad one isn't a crash
+	// artifact and shouldn't be papered over
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Join(s.dir, snapshotFile), err)
+	}
+	if s.state.Users == nil {
+		s.state.Users = make(map[string]map[string]server.Envelope)
+	}
+	return nil
+}
+
+// replay applies the log of the snapshot's generation, truncating it after
+// the last intact record, and leaves it open for appending.
+func (s *logStore) replay() error {
+	path := s.logPath(s.state.Gen)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	var good int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("%s: dropping a change cut short at offset %d", path, good)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		rec, ok := decodeRecord(line)
+		if !ok {
+			log.Printf("%s: dropping damaged changes from offset %d", path, good)
+			break
+		}
+		s.apply(rec)
+		s.records++
+		good += int64(len(line))
+	}
+
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.log = f
+	return nil
+}
+
+// removeStaleLogs deletes the logs of other generations, left behind by a
+// crash while snapshotting.
+func (s *logStore) removeStaleLogs() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		gen, ok := strings.CutPrefix(e.Name(), logPrefix)
+		if n, err := strconv.Atoi(gen); ok && err == nil && n != s.state.Gen {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
+
+func (s *logStore) logPath(gen int) string {
+	return filepath.Join(s.dir, logPrefix+strconv.Itoa(gen))
+}
+
+func (s *logStore) apply(rec logRecord) {
+	putNote(s.state.Users, rec.User, rec.Note)
+}
+
+func (s *logStore) Notes(user string) (map[string]server.Envelope, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notes := make(map[string]server.Envelope, len(s.state.Users[user]))
+	for id, e := range s.state.Users[user] {
+		notes[id] = e
+	}
+	return notes, nil
+}
+
+func (s *logStore) Put(user string, e server.Envelope) error {
+	return s.append(logRecord{User: user, Note: e})
+}
+
+// append writes rec to the log and syncs it, then applies it.
+func (s *logStore) append(rec logRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return errors.New("store is closed")
+	}
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	end, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = s.log.Write(line)
+	if err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		// drop what reached the file, so later changes don't follow a
+		// torn record that replay would stop at
+		s.rollback(end)
+		return err
+	}
+	s.apply(rec)
+	s.records++
+	if s.records >= snapshotEvery {
+		if err := s.snapshot(); err != nil {
+			// the change is safe in the log; try again next time
+			log.Println("Snapshot failed:", err)
+		}
+	}
+	return nil
+}
+
+// rollback cuts the log back to end. If it can't, the log is closed, as a
+// record written after the damage would be lost on replay.
+func (s *logStore) rollback(end int64) {
+	err := s.log.Truncate(end)
+	if err == nil {
+		_, err = s.log.Seek(end, io.SeekStart)
+	}
+	if err == nil {
+		err = s.log.Sync()
+	}
+	if err != nil {
+		log.Println("Undoing a failed write:", err, "- refusing further changes")
+		s.log.Close()
+		s.log = nil
+	}
+}
+
+// snapshot writes the state as the next generation and starts its log.
+func (s *logStore) snapshot() error {
+	next := s.state
+	next.Gen++
+	data, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.logPath(next.Gen), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(s.dir, snapshotFile), data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	old := s.log
+	s.state, s.log, s.records = next, f, 0
+	old.Close()
+	os.Remove(s.logPath(next.Gen - 1))
+	return nil
+}
+
+// Close snapshots the state, so the next start needn't replay the log.
+func (s *logStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return nil
+	}
+	var err error
+	if s.records > 0 {
+		err = s.snapshot()
+	}
+	s.log.Close()
+	s.log = nil
+	return err
+}
+
+func encodeRecord(rec logRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Appendf(nil, "%08x ", crc32.ChecksumIEEE(data))
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+func decodeRecord(line []byte) (logRecord, bool) {
+	var rec logRecord
+	sum, data, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return rec, false
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || uint32(want) != crc32.ChecksumIEEE(data) {
+		return rec, false
+	}
+	return rec, json.Unmarshal(data, &rec) == nil
+}
+
+// writeFileSync replaces path with data, syncing the file and its directory
+// so the new contents survive a crash.
+func writeFileSync(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/electr1fy0/blue/server"
+)
+
+func putN(t *testing.T, s *logStore, ids ...string) {
+	t.Helper()
+	for i, id := range ids {
+		e := server.Envelope{Type: server.MsgPut, ID: id, Version: 1, Seq: int64(i + 1), Blob: []byte(id)}
+		if err := s.Put("alice", e); err != nil {
+			t.Fatalf("Put %s: %v", id, err)
+		}
+	}
+}
+
+// crash drops the store without snapshotting, as a crash would.
+func crash(s *logStore) {
+	s.log.Close()
+	s.log = nil
+}
+
+func reopen(t *testing.T, dir string) *logStore {
+	t.Helper()
+	s, err := openLogStore(dir)
+	if err != nil {
+		t.Fatalf("openLogStore: %v", err)
+	}
+	return s
+}
+
+func wantNotes(t *testing.T, s *logStore, ids ...string) {
+	t.Helper()
+	notes, err := s.Notes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != len(ids) {
+		t.Fatalf("got %d notes, want %v", len(notes), ids)
+	}
+	for _, id := range ids {
+		if string(notes[id].Blob) != id {
+			t.Errorf("note %s: got %q", id, notes[id].Blob)
+		}
+	}
+}
+
+func TestLogStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := reopen(t, dir)
+	putN(t, s, "a", "b")
+	crash(s)
+
+	s = reopen(t, dir)
+	wantNotes(t, s, "a", "b")
+	if s.records != 2 {
+		t.Errorf("records = %d, want 2", s.records)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// from the snapshot Close wrote, with an empty log
+	s = reopen(t, dir)
+	defer s.Close()
+	wantNotes(t, s, "a", "b")
+	if s.records != 0 {
+		t.Errorf("records = %d after a snapshot, want 0", s.records)
+	}
+}
+
+func TestLogStoreTornTail(t *testing.T) {
+	for _, tail := range []string{
+		`0000`,                              // cut short
+		"00000000 {\"user\":\"alice\"}\n",   // bad checksum
+		"not a record at all\n",             // garbage
+		"1234abcd {\"user\":\"alice\"}\nxx", // damage followed by more
+	} {
+		dir := t.TempDir()
+		s := reopen(t, dir)
+		putN(t, s, "a", "b")
+		path := s.log.Name()
+		crash(s)
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tail)
+		f.Close()
+
+		s = reopen(t, dir)
+		wantNotes(t, s, "a", "b")
+		// later changes go after the last good record, so they survive
+		putN(t, s, "c")
+		crash(s)
+		s = reopen(t, dir)
+		wantNotes(t, s, "a", "b", "c")
+		s.Close()
+	}
+}
+
+func TestLogStoreRollback(t *testing.T) {
+	dir := t.TempDir()
+	s := reopen(t, dir)
+	putN(t, s, "a")
+
+	// a write that failed halfway
+	end, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.log.WriteString(`1234 {"user":"al`)
+	s.rollback(end)
+	if s.log == nil {
+		t.Fatal("rollback closed the log")
+	}
+
+	putN(t, s, "b")
+	crash(s)
+	s = reopen(t, dir)
+	defer s.Close()
+	wantNotes(t, s, "a", "b")
+}
+
+func TestLogStoreClosed(t *testing.T) {
+	s := reopen(t, t.TempDir())
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("alice", server.Envelope{Type: server.MsgPut, ID: "a"}); err == nil {
+		t.Error("Put on a closed store succeeded")
+	}
+}
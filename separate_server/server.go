@@ -1,94 +1,172 @@
 // This is separate from the rest of the project
 // Run it independently to enable real time note syncing
+//
+// Notes arrive encrypted with a key only the clients have; the server
+// stores and relays them without being able to read them. Clients log in
+// with a token from the user store (see the token command) or with the
+// pre-shared secret in $BLUE_SYNC_SECRET, and each user's notes are kept
+// apart from everyone else's.
 
 package main
 
 import (
+	"cmp"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"maps"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/electr1fy0/blue/server"
 	"github.com/gorilla/websocket"
 )
 
-type Note struct {
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	UpdatedAt time.Time `json:"updated_at"`
-}
-
-type WSMessage struct {
-	Type string `json:"type"` // "sync", "add", "edit", "delete"
-	Note *Note  `json:"note,omitempty"`
-}
-
+// Hub relays notes between the clients of one user. It only holds what
+// they send it: encrypted blobs filed under their note IDs, which it can't
+// read. Every change goes to the store before it is relayed.
 type Hub struct {
-	clients    map[*websocket.Conn]bool
-	notes      map[string]Note
+	user       string
+	store      Store
+	clients    map[*websocket.Conn]string // connection → token ID
+	notes      map[string]server.Envelope // latest change to each note
+	seq        int64                      // of the latest change
 	mu         sync.Mutex
-	broadcast  chan WSMessage
-	register   chan *websocket.Conn
+	broadcast  chan change
+	register   chan client
 	unregister chan *websocket.Conn
+	check      chan func(tokenID string) bool
+}
+
+// change is a message from a client, to store and relay.
+type change struct {
+	from *websocket.Conn
+	server.Envelope
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+type client struct {
+	conn    *websocket.Conn
+	tokenID string
+	since   int64 // last sequence number it saw
 }
 
-func newHub() *Hub {
-	return &Hub{
-		clients:    make(map[*websocket.Conn]bool),
-		notes:      make(map[string]Note),
-		broadcast:  make(chan WSMessage),
-		register:   make(chan *websocket.Conn),
+const (
+	// maxMessage bounds a single message, i.e. one encrypted note.
+	maxMessage = 16 << 20
+	// helloTimeout is how long a client has to say hello.
+	helloTimeout = 10 * time.Second
+	// writeTimeout is how long a client may take to accept a message
+	// before it is dropped, so that it can't hold up the others.
+	writeTimeout = 10 * time.Second
+
+	// secretEnv holds the pre-shared secret, if one is used. Everyone
+	// who has it is the same user, secretUser, which no token user can be.
+	secretEnv     = "BLUE_SYNC_SECRET"
+	secretUser    = "@shared"
+	secretTokenID = "secret"
+
+	// usersCheck is how often the user store is reread, to drop the
+	// connections of revoked tokens.
+	usersCheck = 5 * time.Second
+)
+
+// Native clients send no Origin header, so the default check only turns
+// away other websites.
+var upgrader = websocket.Upgrader{}
+
+func newHub(user string, store Store) (*Hub, error) {
+	notes, err := store.Notes(user)
+	if err != nil {
+		return nil, err
+	}
+	h := &Hub{
+		user:       user,
+		store:      store,
+		clients:    make(map[*websocket.Conn]string),
+		notes:      notes,
+		broadcast:  make(chan change),
+		register:   make(chan client),
 		unregister: make(chan *websocket.Conn),
+		check:      make(chan func(string) bool),
+	}
+	for _, e := range notes {
+		h.seq = max(h.seq, e.Seq)
+	}
+	return h, nil
+}
+
+// changes returns the changes after since in order, or every note if the
+// client has seen nothing or a sequence number from before the server's
+// notes were lost. Deleted notes are included either way: a client needs
+// their versions to send changes to them.
+func (h *Hub) changes(since int64) server.Envelope {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg := server.Envelope{Type: server.MsgChanges, Seq: h.seq}
+	msg.Full = since <= 0 || since > h.seq
+	for _, e := range h.notes {
+		if msg.Full || e.Seq > since {
+			msg.Notes = append(msg.Notes, e)
+		}
 	}
+	slices.SortFunc(msg.Notes, func(a, b server.Envelope) int { return cmp.Compare(a.Seq, b.Seq) })
+	return msg
 }
 
 func (h *Hub) run() {
 	for {
 		select {
-		case conn := <-h.register:
-			h.clients[conn] = true
+		case c := <-h.register:
+			h.clients[c.conn] = c.tokenID
 			log.Println("Client registered")
-			h.mu.Lock()
-			fullSync := make([]Note, 0, len(h.notes))
-			for _, note := range h.notes {
-				fullSync = append(fullSync, note)
+			changes := h.changes(c.since)
+			hello := server.Envelope{Type: server.MsgHello, Protocol: server.ProtocolVersion, Seq: changes.Seq}
+			if send(c.conn, hello) != nil || send(c.conn, changes) != nil {
+				c.conn.Close()
+				delete(h.clients, c.conn)
 			}
-			h.mu.Unlock()
-			msg := WSMessage{
-				Type: "full_sync",
-			}
-			data, _ := json.Marshal(fullSync)
-
-			conn.WriteMessage(websocket.TextMessage, data)
-			_ = conn.WriteJSON(msg)
 		case conn := <-h.unregister:
 			if _, ok := h.clients[conn]; ok {
 				delete(h.clients, conn)
 				conn.Close()
 				log.Println("Client unregistered")
 			}
-		case message := <-h.broadcast:
-			h.mu.Lock()
-			switch message.Type {
-			case "add", "edit":
-				current, exists := h.notes[message.Note.Title]
-				if !exists || message.Note.UpdatedAt.After(current.UpdatedAt) {
-					h.notes[message.Note.Title] = *message.Note
+		case valid := <-h.check:
+			for conn, id := range h.clients {
+				if !valid(id) {
+					log.Printf("Disconnecting revoked token %s", id)
+					conn.Close()
+					delete(h.clients, conn)
 				}
-			case "delete":
-				delete(h.notes, message.Note.Title)
 			}
-			h.mu.Unlock()
+		case c := <-h.broadcast:
+			message := c.Envelope
+			if current, stale := h.stale(message); stale {
+				// the client hears of the change it missed first, as
+				// that went out before this
+				if send(c.from, server.Envelope{Type: server.MsgConflict, ID: message.ID, Version: current}) != nil {
+					c.from.Close()
+					delete(h.clients, c.from)
+				}
+				continue
+			}
+			if err := h.save(&message); err != nil {
+				// not relayed, so clients still have it to send again
+				log.Println("Storing change:", err)
+				continue
+			}
 
 			for c := range h.clients {
-				err := c.WriteJSON(message)
+				err := send(c, message)
 				if err != nil {
 					log.Println("Error broadcasting:", err)
 					c.Close()
@@ -99,13 +177,138 @@ func (h *Hub) run() {
 	}
 }
 
-func wsHandler(h *Hub, w http.ResponseWriter, r *http.Request) {
+// send writes v to conn, giving up after writeTimeout.
+func send(conn *websocket.Conn, v any) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteJSON(v)
+}
+
+// stale reports whether message was made from another version of the note
+// than the latest, and returns the latest.
+func (h *Hub) stale(message server.Envelope) (int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	current, ok := h.notes[message.ID]
+	return current.Version, ok && message.Base != current.Version
+}
+
+// save stamps a change with the note's next version, the time and the next
+// sequence number, and writes it to the store, then to the hub's notes.
+func (h *Hub) save(message *server.Envelope) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	message.Time = time.Now().UTC()
+	message.Version = h.notes[message.ID].Version + 1
+	message.Seq = h.seq + 1
+	if err := h.store.Put(h.user, *message); err != nil {
+		return err
+	}
+	h.notes[message.ID] = *message
+	h.seq = message.Seq
+	return nil
+}
+
+// syncServer authenticates connections and hands each to its user's hub.
+type syncServer struct {
+	users  *userStore
+	secret string
+	store  Store
+
+	mu   sync.Mutex
+	hubs map[string]*Hub // by user
+}
+
+// hub returns the hub of user, starting it on first use.
+func (s *syncServer) hub(user string) (*Hub, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.hubs[user]; ok {
+		return h, nil
+	}
+	h, err := newHub(user, s.store)
+	if err != nil {
+		return nil, err
+	}
+	s.hubs[user] = h
+	go h.run()
+	return h, nil
+}
+
+// authenticate returns the user and token ID of the bearer token on r.
+func (s *syncServer) authenticate(r *http.Request) (user, tokenID string, ok bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", "", false
+	}
+	if s.secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1 {
+		return secretUser, secretTokenID, true
+	}
+	if _, err := s.users.reload(); err != nil {
+		log.Println("Reading users:", err)
+	}
+	t, ok := s.users.lookup(token)
+	return t.User, t.ID, ok
+}
+
+func (s *syncServer) handleWS(w http.ResponseWriter, r *http.Request) {
+	user, tokenID, ok := s.authenticate(r)
+	if !ok {
+		log.Printf("Rejected connection from %s", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="blue"`)
+		http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+	h, err := s.hub(user)
+	if err != nil {
+		log.Println("Loading notes:", err)
+		http.Error(w, "can't load notes", http.StatusInternalServerError)
+		return
+	}
+	wsHandler(h, tokenID, w, r)
+}
+
+// watchUsers rereads the user store now and then, disconnecting clients
+// whose token was revoked. The hubs check their clients on every tick, not
+// only when the file changed, as authenticate may have reread it first.
+func (s *syncServer) watchUsers() {
+	for range time.Tick(usersCheck) {
+		if _, err := s.users.reload(); err != nil {
+			log.Println("Reading users:", err)
+			continue
+		}
+		// a hub busy with a slow client mustn't keep other users' hubs
+		// from starting
+		s.mu.Lock()
+		hubs := slices.Collect(maps.Values(s.hubs))
+		s.mu.Unlock()
+		for _, h := range hubs {
+			h.check <- s.tokenValid
+		}
+	}
+}
+
+func (s *syncServer) tokenValid(id string) bool {
+	if id == secretTokenID {
+		return s.secret != ""
+	}
+	return s.users.valid(id)
+}
+
+func wsHandler(h *Hub, tokenID string, w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
-	h.register <- conn
+	conn.SetReadLimit(maxMessage)
+	since, err := readHello(conn)
+	if err != nil {
+		log.Println("Handshake failed:", err)
+		_ = send(conn, server.Envelope{Type: server.MsgError, Error: err.Error()})
+		conn.Close()
+		return
+	}
+	h.register <- client{conn, tokenID, since}
 
 	defer func() {
 		h.unregister <- conn
@@ -118,30 +321,131 @@ func wsHandler(h *Hub, w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		var wsMsg WSMessage
-		if err := json.Unmarshal(msg, &wsMsg); err != nil {
+		var e server.Envelope
+		if err := json.Unmarshal(msg, &e); err != nil {
 			log.Println("Invalid message:", err)
 			continue
 		}
-
-		if wsMsg.Type == "add" || wsMsg.Type == "edit" {
-			wsMsg.Note.UpdatedAt = time.Now()
+		if e.ID == "" || len(e.ID) > 64 {
+			log.Println("Invalid message: bad note id")
+			continue
 		}
+		switch e.Type {
+		case server.MsgPut:
+			if len(e.Blob) == 0 {
+				log.Println("Invalid message: put without a note")
+				continue
+			}
+		case server.MsgDelete:
+			e.Blob = nil
+		default:
+			// this includes plaintext notes from older clients
+			log.Printf("Invalid message: unsupported type %q", e.Type)
+			continue
+		}
+		// version, time and sequence are the server's to give
+		e = server.Envelope{Type: e.Type, ID: e.ID, Blob: e.Blob, Base: e.Base}
 
-		h.broadcast <- wsMsg
+		h.broadcast <- change{conn, e}
 	}
 }
 
-func main() {
-	hub := newHub()
-	go hub.run()
+func serve(addr, usersPath, storeKind, dataDir string) error {
+	users, err := loadUsers(usersPath)
+	if err != nil {
+		return err
+	}
+	store, err := openStore(storeKind, dataDir)
+	if err != nil {
+		return err
+	}
+	s := &syncServer{
+		users:  users,
+		secret: os.Getenv(secretEnv),
+		store:  store,
+		hubs:   make(map[string]*Hub),
+	}
+	if len(users.list()) == 0 && s.secret == "" {
+		log.Printf("No tokens in %s and $%s is unset; every connection will be refused until a token is created", usersPath, secretEnv)
+	}
+	go s.watchUsers()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWS)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	log.Printf("Collaboration server listening on %s", addr)
+	select {
+	case err = <-errc:
+	case <-stop:
+		log.Println("Shutting down")
+		_ = srv.Close()
+	}
+	// changes still arriving fail to store and so aren't relayed either
+	if cerr := store.Close(); cerr != nil {
+		return cerr
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
 
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		wsHandler(hub, w, r)
-	})
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %[1]s [-addr :8080] [-users users.json] [-data dir] [serve]
+       %[1]s [-users users.json] token create <user>
+       %[1]s [-users users.json] token list
+       %[1]s [-users users.json] token revoke <id|token>
 
-	log.Println("Collaboration server listening on :8080")
-	err := http.ListenAndServe(":8080", nil)
+Clients connect with a token as their sync_token. Instead of tokens, a
+secret shared by all clients can be set in $%[2]s.
+
+`, os.Args[0], secretEnv)
+	flag.PrintDefaults()
+}
+
+// readHello waits for the client's hello and returns the last sequence
+// number it saw.
+func readHello(conn *websocket.Conn) (int64, error) {
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	var hello server.Envelope
+	if err := conn.ReadJSON(&hello); err != nil {
+		return 0, fmt.Errorf("no hello: %w", err)
+	}
+	if hello.Type != server.MsgHello {
+		return 0, fmt.Errorf("expected hello, got %q; the client may need updating", hello.Type)
+	}
+	if hello.Protocol != server.ProtocolVersion {
+		return 0, fmt.Errorf("client speaks protocol %d, server %d", hello.Protocol, server.ProtocolVersion)
+	}
+	return hello.Since, nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	usersPath := flag.String("users", "users.json", "file of tokens allowed to connect")
+	storeKind := flag.String("store", "log", "where notes are kept: log, or memory to lose them on exit")
+	dataDir := flag.String("data", "data", "directory of the log store")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	var err error
+	switch {
+	case len(args) == 0 || args[0] == "serve":
+		err = serve(*addr, *usersPath, *storeKind, *dataDir)
+	case args[0] == "token":
+		err = tokenCommand(*usersPath, args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
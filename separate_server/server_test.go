@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/electr1fy0/blue/server"
+	"github.com/gorilla/websocket"
+)
+
+// testHub serves a hub with a memory store to clients of one user.
+func testHub(t *testing.T) string {
+	t.Helper()
+	h, err := newHub("alice", newMemStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go h.run()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsHandler(h, "token", w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dial connects to the hub at url, says hello and returns the connection
+// with the changes the hub answered with.
+func dial(t *testing.T, url string, since int64) (*websocket.Conn, server.Envelope) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	write(t, conn, server.Envelope{Type: server.MsgHello, Protocol: server.ProtocolVersion, Since: since})
+	if hello := read(t, conn); hello.Type != server.MsgHello {
+		t.Fatalf("got %s, want hello", hello.Type)
+	}
+	changes := read(t, conn)
+	if changes.Type != server.MsgChanges {
+		t.Fatalf("got %s, want changes", changes.Type)
+	}
+	return conn, changes
+}
+
+func write(t *testing.T, conn *websocket.Conn, e server.Envelope) {
+	t.Helper()
+	if err := conn.WriteJSON(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, conn *websocket.Conn) server.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var e server.Envelope
+	if err := conn.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestChangesSince(t *testing.T) {
+	url := testHub(t)
+	a, _ := dial(t, url, 0)
+	write(t, a, server.Envelope{Type: server.MsgPut, ID: "x", Blob: []byte("1")})
+	read(t, a)
+	write(t, a, server.Envelope{Type: server.MsgPut, ID: "y", Blob: []byte("1")})
+	read(t, a)
+	write(t, a, server.Envelope{Type: server.MsgPut, ID: "x", Blob: []byte("2"), Base: 1})
+	read(t, a)
+
+	_, changes := dial(t, url, 2)
+	if changes.Full || changes.Seq != 3 || len(changes.Notes) != 1 || changes.Notes[0].Version != 2 {
+		t.Errorf("since 2: got %+v, want x at version 2", changes)
+	}
+	for _, since := range []int64{0, 9} {
+		if _, changes := dial(t, url, since); !changes.Full || len(changes.Notes) != 2 {
+			t.Errorf("since %d: got %+v, want both notes in full", since, changes)
+		}
+	}
+}
+
+// A client that syncs from scratch must learn of deleted notes, or a note
+// of its own with the same ID is refused for ever.
+func TestFullChangesIncludeDeletions(t *testing.T) {
+	url := testHub(t)
+	a, _ := dial(t, url, 0)
+	write(t, a, server.Envelope{Type: server.MsgPut, ID: "x", Blob: []byte("1")})
+	read(t, a)
+	write(t, a, server.Envelope{Type: server.MsgDelete, ID: "x", Base: 1})
+	if e := read(t, a); e.Type != server.MsgDelete || e.Version != 2 {
+		t.Fatalf("got %+v, want the delete at version 2", e)
+	}
+
+	b, changes := dial(t, url, 0)
+	if !changes.Full || len(changes.Notes) != 1 {
+		t.Fatalf("got %+v, want a full list with the deletion", changes)
+	}
+	del := changes.Notes[0]
+	if del.Type != server.MsgDelete || del.ID != "x" || del.Version != 2 {
+		t.Fatalf("got %+v, want x deleted at version 2", del)
+	}
+
+	// not made from the deletion: refused
+	write(t, b, server.Envelope{Type: server.MsgPut, ID: "x", Blob: []byte("b")})
+	if e := read(t, b); e.Type != server.MsgConflict || e.Version != 2 {
+		t.Fatalf("got %+v, want a conflict at version 2", e)
+	}
+	// made from it: accepted and relayed
+	write(t, b, server.Envelope{Type: server.MsgPut, ID: "x", Blob: []byte("b"), Base: del.Version})
+	for _, c := range []*websocket.Conn{a, b} {
+		if e := read(t, c); e.Type != server.MsgPut || e.Version != 3 || string(e.Blob) != "b" {
+			t.Errorf("got %+v, want x restored at version 3", e)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/electr1fy0/blue/server"
+)
+
+// Store keeps the notes of every user, so they outlive the server process.
+// Hubs read a user's notes once, when the user first connects, and write
+// every change through before relaying it.
+//
+// A store holds the latest change to each note: a MsgPut with the note, or
+// for a deleted note a MsgDelete, kept so that clients which were away
+// learn of the deletion.
+type Store interface {
+	// Notes returns the latest change to every note of user, by ID.
+	Notes(user string) (map[string]server.Envelope, error)
+	// Put stores a change, replacing the earlier one to the same note.
+	Put(user string, e server.Envelope) error
+	// Close flushes anything pending. The store can't be used afterwards.
+	Close() error
+}
+
+// openStore opens the store named kind with its data under dir.
+func openStore(kind, dir string) (Store, error) {
+	switch kind {
+	case "log":
+		return openLogStore(dir)
+	case "memory":
+		return newMemStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q (want log or memory)", kind)
+	}
+}
+
+// memStore keeps notes in memory only, for trying the server out; they are
+// lost when it stops.
+type memStore struct {
+	mu    sync.Mutex
+	users map[string]map[string]server.Envelope
+}
+
+func newMemStore() *memStore {
+	return &memStore{users: make(map[string]map[string]server.Envelope)}
+}
+
+func (s *memStore) Notes(user string) (map[string]server.Envelope, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notes := make(map[string]server.Envelope, len(s.users[user]))
+	for id, e := range s.users[user] {
+		notes[id] = e
+	}
+	return notes, nil
+}
+
+func (s *memStore) Put(user string, e server.Envelope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	putNote(s.users, user, e)
+	return nil
+}
+
+func (s *memStore) Close() error { return nil }
+
+func putNote(users map[string]map[string]server.Envelope, user string, e server.Envelope) {
+	notes, ok := users[user]
+	if !ok {
+		notes = make(map[string]server.Envelope)
+		users[user] = notes
+	}
+	notes[e.ID] = e
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenPrefix starts every token, so one is easy to recognise in a config
+// file or a leak scanner.
+const tokenPrefix = "blue_"
+
+var userNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// tokenRecord is a token as the server keeps it: only its hash, so a copy of
+// the user store doesn't let anyone in.
+type tokenRecord struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Hash    string    `json:"hash"` // hex SHA-256 of the token
+	Created time.Time `json:"created"`
+}
+
+// userStore is the file of tokens that may connect, and the user each
+// belongs to. The token commands change the file while the server runs; the
+// server picks the changes up with reload.
+type userStore struct {
+	path string
+
+	mu     sync.Mutex
+	stamp  fileStamp
+	tokens []tokenRecord
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+type usersFile struct {
+	Tokens []tokenRecord `json:"tokens"`
+}
+
+// loadUsers reads the user store at path. A missing file is an empty store.
+func loadUsers(path string) (*userStore, error) {
+	s := &userStore{path: path}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload rereads the file if it changed since it was last read, and
+// reports whether it did.
+func (s *userStore) reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stamp fileStamp
+	fi, err := os.Stat(s.path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return false, err
+	default:
+		stamp = fileStamp{fi.ModTime(), fi.Size()}
+	}
+	if stamp == s.stamp && s.tokens != nil {
+		return false, nil
+	}
+
+	var f usersFile
+	if stamp != (fileStamp{}) {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return false, err
+		}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return false, fmt.Errorf("%s: %w", s.path, err)
+		}
+	}
+	s.stamp = stamp
+	s.tokens = append([]tokenRecord{}, f.Tokens...)
+	return true, nil
+}
+
+// lookup returns the record of token, if it may connect.
+func (s *userStore) lookup(token string) (tokenRecord, bool) {
+	h := hashToken(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Hash == h {
+			return t, true
+		}
+	}
+	return tokenRecord{}, false
+}
+
+// valid reports whether the token with the given ID is still in the store.
+func (s *userStore) valid(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns every token record.
+func (s *userStore) list() []tokenRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]tokenRecord{}, s.tokens...)
+}
+
+// create adds a token for user and returns it. It can't be shown again.
+func (s *userStore) create(user string) (string, tokenRecord, error) {
+	if !userNameRe.MatchString(user) {
+		return "", tokenRecord{}, fmt.Errorf("invalid user name %q", user)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", tokenRecord{}, err
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	h := hashToken(token)
+	rec := tokenRecord{ID: h[:8], User: user, Hash: h, Created: time.Now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, rec)
+	return token, rec, s.save()
+}
+
+// revoke removes the token given by ID or by value.
+func (s *userStore) revoke(idOrToken string) (tokenRecord, error) {
+	h := hashToken(idOrToken)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, t := range s.tokens {
+		if t.ID == idOrToken || t.Hash == h {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return t, s.save()
+		}
+	}
+	return tokenRecord{}, fmt.Errorf("no token %q", idOrToken)
+}
+
+// save writes the store atomically, readable only by its owner.
+func (s *userStore) save() error {
+	data, err := json.MarshalIndent(usersFile{Tokens: s.tokens}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".users-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return hex.EncodeToString(h[:])
+}
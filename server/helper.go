@@ -1,13 +1,59 @@
 package server
 
-import "github.com/gorilla/websocket"
+import (
+	"errors"
+	"net/http"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultURL is the sync server used when a vault doesn't set one.
+const DefaultURL = "ws://localhost:8080/ws"
 
 type WsConnected struct {
 	Conn *websocket.Conn
 }
 type WsMessage struct {
+	Conn *websocket.Conn
 	Data []byte
 }
 type WsError struct {
-	Err error
+	Conn *websocket.Conn // nil if the dial itself failed
+	Err  error
+}
+
+// ErrUnauthorized is reported when the sync server refuses the token.
+var ErrUnauthorized = errors.New("sync server refused the token; check sync_token")
+
+// Connect dials the sync server, logging in with token if it isn't empty,
+// and reports the result as a message.
+func Connect(url, token string) tea.Cmd {
+	return func() tea.Msg {
+		var header http.Header
+		if token != "" {
+			header = http.Header{"Authorization": {"Bearer " + token}}
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return WsError{Err: ErrUnauthorized}
+		}
+		if err != nil {
+			return WsError{Err: err}
+		}
+		return WsConnected{Conn: conn}
+	}
+}
+
+// Listen waits for the next message on conn. Issue it again after handling
+// each WsMessage to keep reading.
+func Listen(conn *websocket.Conn) tea.Cmd {
+	return func() tea.Msg {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			_ = conn.Close()
+			return WsError{Conn: conn, Err: err}
+		}
+		return WsMessage{Conn: conn, Data: msg}
+	}
 }
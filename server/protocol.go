@@ -0,0 +1,55 @@
+package server
+
+import "time"
+
+// ProtocolVersion is the version of the sync protocol this build speaks.
+// Client and server exchange it in their hello messages and must agree.
+const ProtocolVersion = 3
+
+// Message types on the sync connection.
+const (
+	// MsgHello opens a connection. The client sends its protocol version
+	// and the last sequence number it saw in Since; the server answers
+	// with its protocol version and latest sequence number, then
+	// MsgChanges.
+	MsgHello = "hello"
+	// MsgChanges carries, in Notes, every change after the client's Since
+	// in order. If Full is set it is instead every note the server holds,
+	// deletions included, because the client had seen nothing or a
+	// sequence the server doesn't know.
+	MsgChanges = "changes"
+	// MsgPut stores a note, replacing any earlier version. From the server
+	// it carries the version, time and sequence number the server gave it.
+	MsgPut = "put"
+	// MsgDelete removes a note.
+	MsgDelete = "delete"
+	// MsgConflict refuses a MsgPut or MsgDelete made from a version other
+	// than the server's, i.e. one that would overwrite a change the
+	// client hasn't seen. Version is the server's. It goes only to the
+	// client that sent the change, after the change it conflicts with.
+	MsgConflict = "conflict"
+	// MsgError reports why the server is closing the connection.
+	MsgError = "error"
+)
+
+// Envelope is everything the sync server sees of a note: its ID, the
+// version and time the server gave its last change and the note itself
+// encrypted with the vault's sync key, which the server never has.
+type Envelope struct {
+	Type    string    `json:"type"`
+	ID      string    `json:"id,omitempty"`
+	Version int64     `json:"version,omitempty"`
+	Time    time.Time `json:"time,omitzero"`
+	Blob    []byte    `json:"blob,omitempty"`
+	// Seq numbers every change the server accepts for a user, in order.
+	Seq int64 `json:"seq,omitempty"`
+	// Base is the version a change from a client was made from, 0 for a
+	// note the server didn't have.
+	Base int64 `json:"base,omitempty"`
+
+	Protocol int        `json:"protocol,omitempty"` // MsgHello
+	Since    int64      `json:"since,omitempty"`    // MsgHello from the client
+	Notes    []Envelope `json:"notes,omitempty"`    // MsgChanges
+	Full     bool       `json:"full,omitempty"`     // MsgChanges
+	Error    string     `json:"error,omitempty"`    // MsgError
+}
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LayoutFile = "file" // the whole notebook in one encrypted file
+	LayoutDir  = "dir"  // a directory with one encrypted file per note
+)
+
+// DefaultLayout is used for newly created vaults. Single-file vaults are
+// converted to it when unlocked.
+var DefaultLayout = LayoutDir
+
+// WatchInterval is how often Watch checks the vault for outside changes.
+var WatchInterval = time.Second
+
+// ErrChangedOnDisk is returned by Save when something else changed the
+// vault since this backend last read or wrote it. Load and merge first.
+var ErrChangedOnDisk = errors.New("vault changed on disk")
+
+// Backend stores the notebook of one unlocked vault.
+type Backend interface {
+	// Load decrypts the stored notebook.
+	Load() (*Notebook, error)
+	// Save writes nb, possibly skipping notes unchanged since the last Load
+	// or Save. It refuses with ErrChangedOnDisk rather than overwrite a
+	// change it hasn't loaded. It may be called from any goroutine; nb must
+	// not change while it runs.
+	Save(nb *Notebook) error
+	// List returns the IDs of the stored notes, including trashed ones.
+	List() ([]string, error)
+	// Watch reports changes made to the vault by anything other than this
+	// backend, until stop is closed.
+	Watch(stop <-chan struct{}) <-chan struct{}
+
+	// ChangePassword re-wraps the data key under newPassword. Note content
+	// is not re-encrypted.
+	ChangePassword(newPassword string) error
+	// AddRecoveryKey generates a new recovery key, replacing any previous
+	// one, and returns it formatted for printing.
+	AddRecoveryKey() (string, error)
+	HasRecoveryKey() bool
+
+	// ReadOnly reports whether writes are refused, either because the vault
+	// was opened with OpenReadOnly or because another session took it over.
+	ReadOnly() bool
+	// Close releases the vault's lock and wipes the data key from memory.
+	Close()
+}
+
+// CreateVault creates the active vault in DefaultLayout with a new data key
+// protected by password.
+func CreateVault(password string) (Backend, *Notebook, error) {
+	if exists, err := NotebookExists(); err != nil {
+		return nil, nil, err
+	} else if exists {
+		return nil, nil, fmt.Errorf("vault %q already exists", activeVault)
+	}
+	path, err := layoutPath(activeVault, DefaultLayout)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := newKeyring(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := openSession(OpenExclusive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var b Backend
+	switch DefaultLayout {
+	case LayoutDir:
+		var db *dirBackend
+		if db, err = createDirBackend(path, keys); err == nil {
+			db.session = s
+			b = db
+		}
+	default:
+		b = &fileBackend{session: s, path: path, keys: keys}
+	}
+	if err != nil {
+		s.release()
+		return nil, nil, err
+	}
+	nb := NewNotebook()
+	if err := b.Save(nb); err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	return b, nb, nil
+}
+
+// OpenVault unlocks the active vault with password and loads its notebook.
+// mode decides what happens if another session has it open.
+func OpenVault(password string, mode OpenMode) (Backend, *Notebook, error) {
+	b, err := unlockVault(SlotPassword, password, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	nb, err := b.Load()
+	if err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	if b, err = convertLayout(b, nb); err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	return b, nb, nil
+}
+
+// RecoverVault unlocks the active vault with a recovery key and replaces
+// the password with newPassword.
+func RecoverVault(recoveryKey, newPassword string) (Backend, *Notebook, error) {
+	b, err := unlockVault(SlotRecovery, normalizeRecoveryKey(recoveryKey), OpenExclusive)
+	if err != nil {
+		return nil, nil, err
+	}
+	nb, err := b.Load()
+	if err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	if b, err = convertLayout(b, nb); err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	if err := b.ChangePassword(newPassword); err != nil {
+		b.Close()
+		return nil, nil, err
+	}
+	return b, nb, nil
+}
+
+// unlockVault checks secret first, so a wrong one fails before the lock
+// is looked at.
+func unlockVault(slotType, secret string, mode OpenMode) (Backend, error) {
+	path, layout, exists, err := vaultLocation(activeVault)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("vault %q does not exist", activeVault)
+	}
+	b, err := openBackend(path, layout, slotType, secret)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openSession(mode)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	switch b := b.(type) {
+	case *fileBackend:
+		b.session = s
+	case *dirBackend:
+		b.session = s
+	}
+	return b, nil
+}
+
+// openBackend unlocks the vault at path without reading any notes. It holds
+// no lock until the caller attaches a session.
+func openBackend(path, layout, slotType, secret string) (Backend, error) {
+	switch layout {
+	case LayoutDir:
+		return openDirBackend(path, slotType, secret)
+	default:
+		return openFileBackend(path, slotType, secret)
+	}
+}
+
+// convertLayout moves a single-file vault into the directory layout, so
+// later saves only rewrite the notes that changed. The old file becomes the
+// newest backup. If the conversion fails, b is returned unchanged.
+func convertLayout(b Backend, nb *Notebook) (Backend, error) {
+	fb, ok := b.(*fileBackend)
+	if !ok || DefaultLayout != LayoutDir || fb.ReadOnly() {
+		return b, nil
+	}
+	target, err := layoutPath(activeVault, LayoutDir)
+	if err != nil {
+		return b, err
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		return b, fmt.Errorf("cannot convert vault: %s already exists", target)
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(target), filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return b, err
+	}
+	defer os.RemoveAll(tmp)
+	db, err := createDirBackend(tmp, fb.keys)
+	if err != nil {
+		return b, err
+	}
+	db.session = fb.session
+	if err := db.Save(nb); err != nil {
+		return b, err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return b, err
+	}
+	db.path = target
+
+	// carry the old backups over so they stay listed and restorable
+	backups, err := filepath.Glob(fb.path + ".bak.*")
+	if err != nil {
+		return db, err
+	}
+	for _, p := range backups {
+		if err := os.Rename(p, target+strings.TrimPrefix(p, fb.path)); err != nil {
+			return db, err
+		}
+	}
+	if err := os.Rename(fb.path, target+".bak."+time.Now().Format(backupTimeFormat)); err != nil {
+		return db, err
+	}
+	syncDir(filepath.Dir(target))
+	return db, pruneBackups(target)
+}
+
+// finishLoad migrates a freshly decrypted notebook and purges expired
+// trash, saving if either changed anything.
+func finishLoad(b Backend, nb *Notebook) (*Notebook, error) {
+	migrated := nb.migrate()
+	purged := nb.purgeExpiredTrash()
+	if (migrated || purged > 0) && !b.ReadOnly() {
+		// write back so the migration or purge only runs once
+		if err := b.Save(nb); err != nil {
+			return nil, err
+		}
+	}
+	return nb, nil
+}
+
+func wrongSecret(slotType string) error {
+	if slotType == SlotRecovery {
+		return fmt.Errorf("invalid recovery key")
+	}
+	return fmt.Errorf("wrong password")
+}
+
+// watchState lets a backend tell its own writes apart from outside ones.
+// Backends hold mu while reading or writing and record the resulting
+// fingerprint in seen.
+type watchState struct {
+	mu   sync.Mutex
+	seen string
+}
+
+// unchanged reports whether the vault is as this backend last saw it. The
+// caller holds mu.
+func (w *watchState) unchanged(fingerprint string) bool {
+	return w.seen == "" || fingerprint == w.seen
+}
+
+// watch polls fingerprint and signals when it differs from the last value
+// this backend wrote or read. Each outside version is signalled once.
+func (w *watchState) watch(stop <-chan struct{}, fingerprint func() string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	go func() {
+		t := time.NewTicker(WatchInterval)
+		defer t.Stop()
+		var signalled string
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			w.mu.Lock()
+			cur := fingerprint()
+			changed := cur != "" && cur != w.seen && cur != signalled
+			w.mu.Unlock()
+			if changed {
+				signalled = cur
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// statFingerprint identifies a file's current version by size and mtime.
+func statFingerprint(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConvertLayout(t *testing.T) {
+	testData(t)
+	DefaultLayout = LayoutFile
+	b, nb, err := CreateVault("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nb.AddNote(&Note{Title: "kept", Content: "# kept\n"})
+	gone := &Note{Title: "gone", Content: "# gone\n"}
+	nb.AddNote(gone)
+	nb.DeleteNote(gone.ID)
+	if err := b.Save(nb); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	file, err := GetNotebookPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a backup from before, which should move with the vault
+	if err := copyFileAtomic(file, file+".bak.20200101-000000"); err != nil {
+		t.Fatal(err)
+	}
+
+	DefaultLayout = LayoutDir
+	b, converted, err := OpenVault("secret", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.(*dirBackend); !ok {
+		t.Fatalf("got a %T, want the directory layout", b)
+	}
+	b.Close()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("the vault file is still there: %v", err)
+	}
+	path, layout, _, err := vaultLocation(activeVault)
+	if err != nil || layout != LayoutDir {
+		t.Fatalf("vault at %s in layout %q: %v", path, layout, err)
+	}
+
+	b, loaded, err := OpenVault("secret", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for _, got := range []*Notebook{converted, loaded} {
+		if len(got.Notes) != 1 || len(got.Trash) != 1 || got.Trash[gone.ID] == nil || got.Trash[gone.ID].Content != gone.Content {
+			t.Errorf("got notes %v and trash %v, want one of each", got.Notes, got.Trash)
+		}
+	}
+
+	// the file became the newest backup, next to the older one
+	backups, err := ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || !strings.HasSuffix(backups[1].Path, ".vaultdir.bak.20200101-000000") {
+		t.Fatalf("unexpected backups %+v", backups)
+	}
+	for _, bk := range backups {
+		if err := verifyBackup(bk.Path, LayoutFile, "secret"); err != nil {
+			t.Errorf("%s: %v", bk.Path, err)
+		}
+	}
+}
+
+// A legacy vault goes to the current format and layout in one step.
+func TestOpenLegacyVaultConverts(t *testing.T) {
+	testData(t)
+	file := writeLegacyVault(t, "secret", v1Notebook)
+
+	b, nb, err := OpenVault("secret", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := onlyNote(t, nb)
+	b.Close()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("the legacy file is still there: %v", err)
+	}
+
+	b, nb, err = OpenVault("secret", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, ok := b.(*dirBackend); !ok {
+		t.Fatalf("got a %T, want the directory layout", b)
+	}
+	if got := onlyNote(t, nb); got.ID != n.ID || got.Title != "Shopping" {
+		t.Errorf("got %+v, want %+v", got, n)
+	}
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const backupTimeFormat = "20060102-150405"
+
+// MaxBackups is how many backups are kept next to the vault. Zero disables
+// backups.
+var MaxBackups = 5
+
+type Backup struct {
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// ListBackups returns the active vault's backups, newest first.
+func ListBackups() ([]Backup, error) {
+	path, err := GetNotebookPath()
+	if err != nil {
+		return nil, err
+	}
+	return listBackups(path)
+}
+
+func listBackups(path string) ([]Backup, error) {
+	prefix := path + ".bak."
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]Backup, 0, len(matches))
+	for _, p := range matches {
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(p, prefix), time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: p, Time: t, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// backupVault copies the vault file or directory at path to a timestamped
+// backup and prunes old ones beyond MaxBackups.
+func backupVault(path string) error {
+	if MaxBackups <= 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	name := path + ".bak." + time.Now().Format(backupTimeFormat)
+	if info.IsDir() {
+		err = copyDirAtomic(path, name)
+	} else {
+		err = copyFileAtomic(path, name)
+	}
+	if err != nil {
+		return err
+	}
+	return pruneBackups(path)
+}
+
+func copyFileAtomic(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data, 0600)
+}
+
+// copyDirAtomic copies a directory tree into a temp directory and renames
+// it to dst, replacing anything already there.
+func copyDirAtomic(src, dst string) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = filepath.WalkDir(src, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(tmp, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0600)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+func pruneBackups(path string) error {
+	backups, err := listBackups(path)
+	if err != nil {
+		return err
+	}
+	for i := MaxBackups; i < len(backups); i++ {
+		if err := os.RemoveAll(backups[i].Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreBackup replaces the vault with the backup at path after checking
+// that password decrypts it. The current vault is backed up first. It fails
+// while another session has the vault open.
+func RestoreBackup(path, password string) error {
+	return withVaultLock(activeVault, func() error {
+		return restoreBackup(path, password)
+	})
+}
+
+func restoreBackup(path, password string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	layout := LayoutFile
+	if info.IsDir() {
+		layout = LayoutDir
+	}
+	if err := verifyBackup(path, layout, password); err != nil {
+		return fmt.Errorf("backup does not decrypt: %w", err)
+	}
+
+	current, _, exists, err := vaultLocation(activeVault)
+	if err != nil {
+		return err
+	}
+	target, err := layoutPath(activeVault, layout)
+	if err != nil {
+		return err
+	}
+
+	// copy the backup aside before backing up the vault, which may prune
+	// it as the oldest
+	staged := target + ".restoring"
+	if layout == LayoutDir {
+		err = copyDirAtomic(path, staged)
+	} else {
+		err = copyFileAtomic(path, staged)
+	}
+	defer os.RemoveAll(staged)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := backupVault(current); err != nil {
+			return fmt.Errorf("backup failed: %w", err)
+		}
+	}
+
+	if layout == LayoutDir {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(staged, target); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(target))
+	if exists && current != target {
+		// the backup was taken in the other layout
+		return os.RemoveAll(current)
+	}
+	return nil
+}
+
+// verifyBackup checks that the vault at path decrypts with password.
+func verifyBackup(path, layout, password string) error {
+	b, err := openBackend(path, layout, SlotPassword, password)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	switch b := b.(type) {
+	case *fileBackend:
+		if b.legacy != nil {
+			return nil
+		}
+		_, err = b.read()
+	case *dirBackend:
+		_, err = b.read()
+	}
+	return err
+}
@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/electr1fy0/blue/crypto"
+)
+
+// The directory layout keeps each note in its own sealed file, so a save
+// only rewrites the notes that changed:
+//
+//	<name>.vaultdir/header.json   format, layout and key slots
+//	<name>.vaultdir/notebook      sealed notebook fields other than notes
+//	<name>.vaultdir/notes/<id>    one sealed note each; trashed notes have DeletedAt set
+const (
+	dirHeaderFile = "header.json"
+	dirMetaFile   = "notebook"
+	dirNotesDir   = "notes"
+)
+
+var safeIDRe = regexp.MustCompile(`^[A-Za-z0-9-]{1,64}$`)
+
+// dirMeta is everything in a Notebook except its notes.
+type dirMeta struct {
+	Version int        `json:"version"`
+	SyncKey []byte     `json:"sync_key,omitempty"`
+	Sync    *SyncState `json:"sync,omitempty"`
+}
+
+type dirBackend struct {
+	*session
+	path     string
+	keys     *keyring
+	backedUp bool
+	watch    watchState
+
+	// written maps note IDs to the digest of the version last written or
+	// read, so Save can skip notes that haven't changed.
+	written  map[string][32]byte
+	metaHash [32]byte
+}
+
+func createDirBackend(path string, keys *keyring) (*dirBackend, error) {
+	if err := os.MkdirAll(filepath.Join(path, dirNotesDir), 0700); err != nil {
+		return nil, err
+	}
+	b := &dirBackend{
+		path:     path,
+		keys:     keys,
+		backedUp: true, // nothing to back up yet
+		written:  make(map[string][32]byte),
+	}
+	if err := b.writeHeader(keys.slots); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func openDirBackend(path, slotType, secret string) (Backend, error) {
+	h, err := readDirHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := unlockKeyring(h.Slots, slotType, secret)
+	if err != nil {
+		return nil, wrongSecret(slotType)
+	}
+	return &dirBackend{path: path, keys: keys, written: make(map[string][32]byte)}, nil
+}
+
+func readDirHeader(path string) (vaultHeader, error) {
+	var h vaultHeader
+	raw, err := os.ReadFile(filepath.Join(path, dirHeaderFile))
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return h, err
+	}
+	if h.Layout != LayoutDir {
+		return h, fmt.Errorf("%s: not a directory vault", path)
+	}
+	return h, nil
+}
+
+func (b *dirBackend) Load() (*Notebook, error) {
+	b.watch.mu.Lock()
+	nb, err := b.read()
+	if err == nil {
+		b.watch.seen = b.fingerprint()
+	}
+	b.watch.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return finishLoad(b, nb)
+}
+
+// read decrypts every note and resets the record of what is on disk.
+func (b *dirBackend) read() (*Notebook, error) {
+	h, err := readDirHeader(b.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta dirMeta
+	if err := b.readSealed(filepath.Join(b.path, dirMetaFile), &meta); err != nil {
+		return nil, err
+	}
+	metaJSON, _ := json.Marshal(meta)
+
+	nb := &Notebook{
+		Version: meta.Version,
+		SyncKey: meta.SyncKey,
+		Sync:    meta.Sync,
+		Notes:   make(map[string]*Note),
+		Trash:   make(map[string]*Note),
+	}
+	written := make(map[string][32]byte)
+
+	ids, err := b.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		var note Note
+		if err := b.readSealed(b.notePath(id), &note); err != nil {
+			return nil, fmt.Errorf("note %s: %w", id, err)
+		}
+		note.ID = id
+		written[id] = noteDigest(&note)
+		if note.DeletedAt.IsZero() {
+			nb.Notes[id] = &note
+		} else {
+			nb.Trash[id] = &note
+		}
+	}
+
+	b.keys.slots = h.Slots
+	b.written = written
+	b.metaHash = sha256.Sum256(metaJSON)
+	return nb, nil
+}
+
+func (b *dirBackend) readSealed(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var data crypto.EncryptedData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	return b.keys.open(data, v)
+}
+
+func (b *dirBackend) writeSealed(path string, plaintext []byte) error {
+	data, err := crypto.Seal(plaintext, b.keys.dataKey)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, raw, 0600)
+}
+
+// Save writes the notes whose content changed since the last read or save
+// and removes files of notes that are gone.
+func (b *dirBackend) Save(nb *Notebook) error {
+	if err := b.checkWrite(); err != nil {
+		return err
+	}
+	b.watch.mu.Lock()
+	defer b.watch.mu.Unlock()
+	if !b.watch.unchanged(b.fingerprint()) {
+		return ErrChangedOnDisk
+	}
+
+	if !b.backedUp {
+		if err := backupVault(b.path); err != nil {
+			return fmt.Errorf("backup failed: %w", err)
+		}
+		b.backedUp = true
+	}
+
+	metaJSON, err := json.Marshal(dirMeta{Version: nb.Version, SyncKey: nb.SyncKey, Sync: nb.Sync})
+	if err != nil {
+		return err
+	}
+	if h := sha256.Sum256(metaJSON); h != b.metaHash {
+		if err := b.writeSealed(filepath.Join(b.path, dirMetaFile), metaJSON); err != nil {
+			return err
+		}
+		b.metaHash = h
+	}
+
+	seen := make(map[string]bool, len(nb.Notes)+len(nb.Trash))
+	for _, notes := range []map[string]*Note{nb.Notes, nb.Trash} {
+		for id, note := range notes {
+			seen[id] = true
+			h := noteDigest(note)
+			if b.written[id] == h {
+				continue
+			}
+			data, err := json.Marshal(note)
+			if err != nil {
+				return err
+			}
+			if err := b.writeSealed(b.notePath(id), data); err != nil {
+				return err
+			}
+			b.written[id] = h
+		}
+	}
+	for id := range b.written {
+		if seen[id] {
+			continue
+		}
+		if err := os.Remove(b.notePath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(b.written, id)
+	}
+	syncDir(filepath.Join(b.path, dirNotesDir))
+
+	b.watch.seen = b.fingerprint()
+	return nil
+}
+
+// List returns note IDs from the file names, without decrypting anything.
+func (b *dirBackend) List() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(b.path, dirNotesDir))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || strings.Contains(e.Name(), ".tmp-") {
+			continue
+		}
+		if id, ok := noteIDFromFile(e.Name()); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (b *dirBackend) Watch(stop <-chan struct{}) <-chan struct{} {
+	return b.watch.watch(stop, b.fingerprint)
+}
+
+// fingerprint summarizes the size and mtime of every file in the vault.
+func (b *dirBackend) fingerprint() string {
+	entries, err := os.ReadDir(filepath.Join(b.path, dirNotesDir))
+	if err != nil {
+		return ""
+	}
+	parts := make([]string, 0, len(entries)+2)
+	parts = append(parts,
+		statFingerprint(filepath.Join(b.path, dirHeaderFile)),
+		statFingerprint(filepath.Join(b.path, dirMetaFile)),
+	)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			continue
+		}
+		parts = append(parts, e.Name()+"="+statFingerprint(filepath.Join(b.path, dirNotesDir, e.Name())))
+	}
+	sort.Strings(parts[2:])
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *dirBackend) ChangePassword(newPassword string) error {
+	return b.replaceSlot(SlotPassword, newPassword)
+}
+
+func (b *dirBackend) AddRecoveryKey() (string, error) {
+	key, err := generateRecoveryKey()
+	if err != nil {
+		return "", err
+	}
+	if err := b.replaceSlot(SlotRecovery, normalizeRecoveryKey(key)); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (b *dirBackend) HasRecoveryKey() bool {
+	return b.keys.hasSlot(SlotRecovery)
+}
+
+func (b *dirBackend) replaceSlot(slotType, secret string) error {
+	if err := b.checkWrite(); err != nil {
+		return err
+	}
+	slots, err := b.keys.withSlot(slotType, secret)
+	if err != nil {
+		return err
+	}
+	b.watch.mu.Lock()
+	defer b.watch.mu.Unlock()
+	if err := b.writeHeader(slots); err != nil {
+		return err
+	}
+	b.keys.slots = slots
+	b.watch.seen = b.fingerprint()
+	return nil
+}
+
+func (b *dirBackend) writeHeader(slots []KeySlot) error {
+	raw, err := json.MarshalIndent(vaultHeader{Format: formatVersion, Layout: LayoutDir, Slots: slots}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(b.path, dirHeaderFile), raw, 0600)
+}
+
+func (b *dirBackend) Close() {
+	b.release()
+	b.keys.wipe()
+}
+
+// noteDigest identifies a version of a note without serializing its
+// history: revisions only change through SetContent, which also moves
+// UpdatedAt, so hashing their count is enough.
+func noteDigest(n *Note) [32]byte {
+	h := sha256.New()
+	for _, s := range []string{n.ID, n.Title, n.Content, n.UpdatedBy} {
+		binary.Write(h, binary.LittleEndian, uint64(len(s)))
+		h.Write([]byte(s))
+	}
+	for _, t := range []time.Time{n.CreatedAt, n.UpdatedAt, n.DeletedAt} {
+		binary.Write(h, binary.LittleEndian, t.UnixNano())
+	}
+	binary.Write(h, binary.LittleEndian, uint64(len(n.Revisions)))
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func (b *dirBackend) notePath(id string) string {
+	return filepath.Join(b.path, dirNotesDir, noteFileName(id))
+}
+
+// noteFileName uses the ID itself when it is a safe file name and a hex
+// encoding (prefixed with '_') otherwise.
+func noteFileName(id string) string {
+	if safeIDRe.MatchString(id) {
+		return id
+	}
+	return "_" + hex.EncodeToString([]byte(id))
+}
+
+func noteIDFromFile(name string) (string, bool) {
+	if rest, ok := strings.CutPrefix(name, "_"); ok {
+		b, err := hex.DecodeString(rest)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+	return name, safeIDRe.MatchString(name)
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportNotes writes every live note to dir as a Markdown file named after
+// its title and returns how many were written.
+func ExportNotes(nb *Notebook, dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	used := make(map[string]bool, len(nb.Notes))
+	for id, note := range nb.Notes {
+		name := strings.ReplaceAll(note.Title, "/", "_")
+		if used[name] {
+			// titles are not unique; disambiguate with the note ID
+			name += "_" + ShortID(id)
+		}
+		used[name] = true
+		path := filepath.Join(dir, name+".md")
+		if err := os.WriteFile(path, []byte(note.Content), 0644); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ShortID returns the first block of a note ID for display and file names.
+func ShortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
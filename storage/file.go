@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/electr1fy0/blue/crypto"
+)
+
+// vaultFile is the single-file layout: the header and the whole notebook,
+// sealed under the data key, in one JSON document.
+type vaultFile struct {
+	vaultHeader
+	Data *crypto.EncryptedData `json:"data"`
+}
+
+type fileBackend struct {
+	*session
+	path     string
+	keys     *keyring
+	backedUp bool // a backup was taken before this session's first write
+	watch    watchState
+
+	// legacy holds the decrypted notebook of a pre-envelope vault until the
+	// first Load rewrites it in the current format.
+	legacy []byte
+}
+
+func openFileBackend(path, slotType, secret string) (Backend, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vf vaultFile
+	if err := json.Unmarshal(raw, &vf); err != nil {
+		return nil, err
+	}
+	if vf.Format == 0 {
+		return openLegacyFile(path, raw, slotType, secret)
+	}
+	keys, err := unlockKeyring(vf.Slots, slotType, secret)
+	if err != nil {
+		return nil, wrongSecret(slotType)
+	}
+	return &fileBackend{path: path, keys: keys}, nil
+}
+
+// openLegacyFile unlocks a file that is a single password-encrypted blob.
+// It gets a fresh data key and is rewritten on Load.
+func openLegacyFile(path string, raw []byte, slotType, password string) (Backend, error) {
+	if slotType != SlotPassword {
+		return nil, fmt.Errorf("vault has no recovery key")
+	}
+	var encryptedData crypto.EncryptedData
+	if err := json.Unmarshal(raw, &encryptedData); err != nil {
+		return nil, err
+	}
+	jsonData, err := crypto.Decrypt(encryptedData, password)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := newKeyring(password)
+	if err != nil {
+		return nil, err
+	}
+	return &fileBackend{path: path, keys: keys, legacy: jsonData}, nil
+}
+
+func (b *fileBackend) Load() (*Notebook, error) {
+	if b.legacy != nil {
+		var nb Notebook
+		if err := json.Unmarshal(b.legacy, &nb); err != nil {
+			return nil, err
+		}
+		nb.migrate()
+		if b.ReadOnly() {
+			return &nb, nil
+		}
+		if err := b.Save(&nb); err != nil {
+			return nil, err
+		}
+		b.legacy = nil
+		return &nb, nil
+	}
+
+	b.watch.mu.Lock()
+	nb, err := b.read()
+	if err == nil {
+		b.watch.seen = statFingerprint(b.path)
+	}
+	b.watch.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return finishLoad(b, nb)
+}
+
+// read decrypts the notebook without migrating it. It also picks up slots
+// changed on disk, e.g. a password changed by another instance.
+func (b *fileBackend) read() (*Notebook, error) {
+	vf, err := b.readFile()
+	if err != nil {
+		return nil, err
+	}
+	if vf.Data == nil {
+		return nil, fmt.Errorf("vault has no data")
+	}
+	var nb Notebook
+	if err := b.keys.open(*vf.Data, &nb); err != nil {
+		return nil, err
+	}
+	b.keys.slots = vf.Slots
+	return &nb, nil
+}
+
+func (b *fileBackend) readFile() (vaultFile, error) {
+	var vf vaultFile
+	raw, err := os.ReadFile(b.path)
+	if err != nil {
+		return vf, err
+	}
+	err = json.Unmarshal(raw, &vf)
+	return vf, err
+}
+
+func (b *fileBackend) Save(nb *Notebook) error {
+	if err := b.checkWrite(); err != nil {
+		return err
+	}
+	data, err := b.keys.seal(nb)
+	if err != nil {
+		return err
+	}
+	b.watch.mu.Lock()
+	defer b.watch.mu.Unlock()
+	if !b.watch.unchanged(statFingerprint(b.path)) {
+		return ErrChangedOnDisk
+	}
+	return b.write(vaultFile{
+		vaultHeader: vaultHeader{Format: formatVersion, Layout: LayoutFile, Slots: b.keys.slots},
+		Data:        data,
+	})
+}
+
+// write saves vf, backing up the existing file first if this session hasn't
+// done so yet. The caller holds watch.mu.
+func (b *fileBackend) write(vf vaultFile) error {
+	if !b.backedUp {
+		if err := backupVault(b.path); err != nil {
+			return fmt.Errorf("backup failed: %w", err)
+		}
+		b.backedUp = true
+	}
+	encryptedJSON, err := json.Marshal(vf)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(b.path, encryptedJSON, 0600); err != nil {
+		return err
+	}
+	b.watch.seen = statFingerprint(b.path)
+	return nil
+}
+
+func (b *fileBackend) List() ([]string, error) {
+	nb, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+	ids := nb.ListNotes()
+	for id := range nb.Trash {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (b *fileBackend) Watch(stop <-chan struct{}) <-chan struct{} {
+	return b.watch.watch(stop, func() string { return statFingerprint(b.path) })
+}
+
+func (b *fileBackend) ChangePassword(newPassword string) error {
+	return b.replaceSlot(SlotPassword, newPassword)
+}
+
+func (b *fileBackend) AddRecoveryKey() (string, error) {
+	key, err := generateRecoveryKey()
+	if err != nil {
+		return "", err
+	}
+	if err := b.replaceSlot(SlotRecovery, normalizeRecoveryKey(key)); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (b *fileBackend) HasRecoveryKey() bool {
+	return b.keys.hasSlot(SlotRecovery)
+}
+
+// replaceSlot rewrites the header with a new slot, leaving the note data
+// untouched.
+func (b *fileBackend) replaceSlot(slotType, secret string) error {
+	if err := b.checkWrite(); err != nil {
+		return err
+	}
+	slots, err := b.keys.withSlot(slotType, secret)
+	if err != nil {
+		return err
+	}
+	// hold the lock from read to write so a concurrent Save isn't undone
+	b.watch.mu.Lock()
+	defer b.watch.mu.Unlock()
+	vf, err := b.readFile()
+	if err != nil {
+		return err
+	}
+	vf.Format = formatVersion
+	vf.Layout = LayoutFile
+	vf.Slots = slots
+	if err := b.write(vf); err != nil {
+		return err
+	}
+	b.keys.slots = slots
+	return nil
+}
+
+func (b *fileBackend) Close() {
+	b.release()
+	b.keys.wipe()
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/electr1fy0/blue/crypto"
+)
+
+// testData points the package at an empty data directory with the default
+// vault active, and makes new keys quick to derive.
+func testData(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	kdf, vault, layout := crypto.DefaultKDF, activeVault, DefaultLayout
+	t.Cleanup(func() { crypto.DefaultKDF, activeVault, DefaultLayout = kdf, vault, layout })
+	crypto.DefaultKDF = crypto.KDFParams{Name: crypto.KDFArgon2id, Memory: 64, Time: 1, Parallelism: 1}
+	activeVault = DefaultVaultName
+}
+
+// writeLegacyVault writes notebook the way vaults were written before key
+// slots: encrypted under a key derived straight from the password with
+// PBKDF2, and without a KDF header.
+func writeLegacyVault(t *testing.T, password, notebook string) string {
+	t.Helper()
+	kdf := crypto.DefaultKDF
+	crypto.DefaultKDF = crypto.KDFParams{Name: crypto.KDFPBKDF2, Time: 100_000}
+	data, err := crypto.Encrypt([]byte(notebook), password)
+	crypto.DefaultKDF = kdf
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.KDF = nil
+	raw, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := layoutPath(activeVault, LayoutFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// a version 1 notebook, which keyed notes by title
+const v1Notebook = `{"version":1,"notes":{"Shopping":{"content":"# Shopping\nmilk\n"}}}`
+
+// onlyNote returns the one live note of nb.
+func onlyNote(t *testing.T, nb *Notebook) *Note {
+	t.Helper()
+	if len(nb.Notes) != 1 {
+		t.Fatalf("got %d notes, want 1", len(nb.Notes))
+	}
+	for id, n := range nb.Notes {
+		if n.ID != id {
+			t.Fatalf("note %q filed under %q", n.ID, id)
+		}
+		return n
+	}
+	return nil
+}
+
+func TestOpenLegacyVault(t *testing.T) {
+	testData(t)
+	DefaultLayout = LayoutFile
+	path := writeLegacyVault(t, "secret", v1Notebook)
+
+	if _, _, err := OpenVault("wrong", OpenExclusive); err == nil {
+		t.Fatal("opened with the wrong password")
+	}
+	b, nb, err := OpenVault("secret", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := onlyNote(t, nb)
+	if nb.Version != currentVersion || n.ID == "Shopping" || n.Title != "Shopping" || n.Content != "# Shopping\nmilk\n" {
+		t.Errorf("not migrated: version %d, note %+v", nb.Version, n)
+	}
+	b.Close()
+
+	// rewritten with a data key wrapped under the password
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vf vaultFile
+	if err := json.Unmarshal(raw, &vf); err != nil {
+		t.Fatal(err)
+	}
+	if vf.Format != formatVersion || len(vf.Slots) != 1 || vf.Slots[0].Type != SlotPassword || vf.Slots[0].Key.Params().Name != crypto.KDFArgon2id {
+		t.Errorf("unexpected header %+v", vf.vaultHeader)
+	}
+
+	// once: the note keeps its new ID
+	b, nb, err = OpenVault("secret", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if again := onlyNote(t, nb); again.ID != n.ID || again.Content != n.Content {
+		t.Errorf("got %+v after reopening, want %+v", again, n)
+	}
+
+	// the legacy file is kept as a backup and can still be restored
+	backups, err := ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want the legacy file", len(backups))
+	}
+	if err := verifyBackup(backups[0].Path, LayoutFile, "secret"); err != nil {
+		t.Errorf("legacy backup: %v", err)
+	}
+}
+
+func TestChangePasswordKeepsData(t *testing.T) {
+	testData(t)
+	DefaultLayout = LayoutFile
+	writeLegacyVault(t, "secret", v1Notebook)
+	b, nb, err := OpenVault("secret", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := onlyNote(t, nb)
+	before, err := b.(*fileBackend).readFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ChangePassword("new"); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	after, err := b.(*fileBackend).readFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after.Data.Ciphertext) != string(before.Data.Ciphertext) {
+		t.Error("changing the password re-encrypted the notes")
+	}
+	if _, _, err := OpenVault("secret", OpenExclusive); err == nil {
+		t.Error("the old password still opens the vault")
+	}
+	b, nb, err = OpenVault("new", OpenExclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if got := onlyNote(t, nb); got.ID != n.ID {
+		t.Errorf("got %+v, want %+v", got, n)
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/electr1fy0/blue/crypto"
+)
+
+const (
+	formatVersion = 1
+
+	SlotPassword = "password"
+	SlotRecovery = "recovery"
+
+	recoveryKeyBytes = 20
+)
+
+// KeySlot is one way to unlock a vault: the data key wrapped under a secret
+// (the password or a recovery key).
+type KeySlot struct {
+	Type string               `json:"type"`
+	Key  crypto.EncryptedData `json:"key"`
+}
+
+// vaultHeader is the unencrypted part of every layout. Notes are encrypted
+// under a random data key; the slots only hold wrapped copies of that key.
+type vaultHeader struct {
+	Format int       `json:"format"`
+	Layout string    `json:"layout,omitempty"`
+	Slots  []KeySlot `json:"slots"`
+}
+
+// keyring is an unlocked data key together with the slots that wrap it.
+type keyring struct {
+	dataKey []byte
+	slots   []KeySlot
+}
+
+// newKeyring generates a data key protected by password.
+func newKeyring(password string) (*keyring, error) {
+	dataKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := crypto.WrapKey(dataKey, password)
+	if err != nil {
+		return nil, err
+	}
+	return &keyring{
+		dataKey: dataKey,
+		slots:   []KeySlot{{Type: SlotPassword, Key: *wrapped}},
+	}, nil
+}
+
+// unlockKeyring tries every slot of the given type against secret.
+func unlockKeyring(slots []KeySlot, slotType, secret string) (*keyring, error) {
+	for _, slot := range slots {
+		if slot.Type != slotType {
+			continue
+		}
+		if key, err := crypto.UnwrapKey(slot.Key, secret); err == nil {
+			return &keyring{dataKey: key, slots: slots}, nil
+		}
+	}
+	return nil, fmt.Errorf("no matching key slot")
+}
+
+// withSlot returns the slots with the data key wrapped under secret as the
+// only slot of slotType. The keyring itself is not changed.
+func (k *keyring) withSlot(slotType, secret string) ([]KeySlot, error) {
+	wrapped, err := crypto.WrapKey(k.dataKey, secret)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]KeySlot, 0, len(k.slots)+1)
+	for _, slot := range k.slots {
+		if slot.Type != slotType {
+			slots = append(slots, slot)
+		}
+	}
+	return append(slots, KeySlot{Type: slotType, Key: *wrapped}), nil
+}
+
+func (k *keyring) hasSlot(slotType string) bool {
+	for _, slot := range k.slots {
+		if slot.Type == slotType {
+			return true
+		}
+	}
+	return false
+}
+
+// seal encrypts v's JSON under the data key.
+func (k *keyring) seal(v any) (*crypto.EncryptedData, error) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Seal(jsonData, k.dataKey)
+}
+
+// open decrypts data sealed by seal into v.
+func (k *keyring) open(data crypto.EncryptedData, v any) error {
+	jsonData, err := crypto.Open(data, k.dataKey)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, v)
+}
+
+// wipe zeroes the data key.
+func (k *keyring) wipe() {
+	crypto.Wipe(k.dataKey)
+	k.dataKey = nil
+}
+
+// generateRecoveryKey returns 160 random bits as base32 in dash-separated
+// groups of four, e.g. ABCD-EFGH-....
+func generateRecoveryKey() (string, error) {
+	b := make([]byte, recoveryKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return groupKey(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
+
+// groupKey splits an encoded key into dash-separated groups of four.
+func groupKey(enc string) string {
+	groups := make([]string, 0, len(enc)/4+1)
+	for i := 0; i < len(enc); i += 4 {
+		groups = append(groups, enc[i:min(i+4, len(enc))])
+	}
+	return strings.Join(groups, "-")
+}
+
+// normalizeRecoveryKey strips separators and case so a key typed back in
+// any reasonable form matches.
+func normalizeRecoveryKey(key string) string {
+	key = strings.ToUpper(key)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, key)
+}
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/electr1fy0/blue/utils"
+)
+
+const (
+	lockExt = ".lock"
+
+	// lockGrace is how long a lock file may stay unreadable before it is
+	// taken for the remains of a crash. Until then the session that created
+	// it may still be writing it.
+	lockGrace = 2 * time.Second
+)
+
+// LockTimeout is how long opening a vault waits for another session to
+// release it before failing with a *LockedError.
+var LockTimeout time.Duration
+
+// OpenMode says how to open a vault that another session may be using.
+type OpenMode int
+
+const (
+	// OpenExclusive locks the vault and fails with a *LockedError if another
+	// live session holds it.
+	OpenExclusive OpenMode = iota
+	// OpenReadOnly opens without locking. Saves fail with ErrReadOnly.
+	OpenReadOnly
+	// OpenTakeover breaks another session's lock. That session's next save
+	// fails with ErrLockLost.
+	OpenTakeover
+)
+
+var (
+	ErrReadOnly = errors.New("vault is open read-only")
+	ErrLockLost = errors.New("vault was taken over by another session; changes are no longer saved")
+)
+
+// LockInfo describes the session holding a vault.
+type LockInfo struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	Token   string    `json:"token"`
+}
+
+// LockedError is returned when a vault is held by another session.
+type LockedError struct {
+	Vault  string
+	Holder LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("vault %q is open in another session (pid %d on %s since %s)",
+		e.Vault, e.Holder.PID, e.Holder.Host, e.Holder.Started.Format("2006-01-02 15:04"))
+}
+
+// vaultLock is an advisory lock: a file next to the vault naming the
+// session that holds it. Sessions that don't check it are not stopped.
+type vaultLock struct {
+	path  string
+	token string
+}
+
+func lockPath(name string) (string, error) {
+	if err := ValidateVaultName(name); err != nil {
+		return "", err
+	}
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+lockExt), nil
+}
+
+// lockVault takes the lock on the vault called name, waiting up to
+// LockTimeout for another session to let go of it.
+func lockVault(name string, takeover bool) (*vaultLock, error) {
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		lock, err := tryLockVault(name, takeover)
+		var locked *LockedError
+		if !errors.As(err, &locked) || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// tryLockVault takes the lock on the vault called name. A lock left by a
+// process that no longer runs on this host, or one still unreadable after
+// lockGrace, is cleared; any other lock is only broken when takeover is set.
+func tryLockVault(name string, takeover bool) (*vaultLock, error) {
+	path, err := lockPath(name)
+	if err != nil {
+		return nil, err
+	}
+	info := LockInfo{
+		PID:     os.Getpid(),
+		Host:    DeviceName(),
+		Started: time.Now(),
+		Token:   NewID(),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_, werr := f.Write(data)
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			if werr != nil {
+				_ = os.Remove(path)
+				return nil, werr
+			}
+			return &vaultLock{path: path, token: info.Token}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		holder, err := readNewLock(path)
+		if err == nil && !takeover && !holder.stale() {
+			return nil, &LockedError{Vault: name, Holder: holder}
+		}
+		// stale, unreadable or being taken over
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("could not lock vault %q", name)
+}
+
+func readLock(path string) (LockInfo, error) {
+	var info LockInfo
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// readNewLock reads the lock at path, waiting while it is unreadable and
+// younger than lockGrace.
+func readNewLock(path string) (LockInfo, error) {
+	for {
+		holder, err := readLock(path)
+		fi, serr := os.Stat(path)
+		if err == nil || serr != nil || time.Since(fi.ModTime()) >= lockGrace {
+			return holder, err
+		}
+		time.Sleep(lockGrace / 20)
+	}
+}
+
+// stale reports whether the lock was left by a process that has exited.
+// Locks from other hosts can't be checked and are never stale.
+func (info LockInfo) stale() bool {
+	if info.Host != DeviceName() {
+		return false
+	}
+	return !utils.ProcessAlive(info.PID)
+}
+
+// held reports whether the lock file still belongs to this session.
+func (l *vaultLock) held() bool {
+	info, err := readLock(l.path)
+	return err == nil && info.Token == l.token
+}
+
+// release removes the lock file if it is still ours.
+func (l *vaultLock) release() {
+	if l.held() {
+		_ = os.Remove(l.path)
+	}
+}
+
+// session is the locking state of an open backend. A backend without one
+// (e.g. a backup being verified) is read-only.
+type session struct {
+	lock     *vaultLock
+	readOnly bool
+	lost     atomic.Bool // another session took the lock
+}
+
+func (s *session) ReadOnly() bool {
+	return s == nil || s.readOnly || s.lost.Load()
+}
+
+// checkWrite fails if this session may not write, and notices when
+// another session has taken the vault over.
+func (s *session) checkWrite() error {
+	switch {
+	case s == nil || s.readOnly:
+		return ErrReadOnly
+	case s.lost.Load():
+		return ErrLockLost
+	case s.lock != nil && !s.lock.held():
+		s.lost.Store(true)
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (s *session) release() {
+	if s != nil && s.lock != nil && !s.lost.Load() {
+		s.lock.release()
+	}
+}
+
+// openSession locks the active vault as mode asks.
+func openSession(mode OpenMode) (*session, error) {
+	if mode == OpenReadOnly {
+		return &session{readOnly: true}, nil
+	}
+	lock, err := lockVault(activeVault, mode == OpenTakeover)
+	if err != nil {
+		return nil, err
+	}
+	return &session{lock: lock}, nil
+}
+
+// withVaultLock runs fn while holding the lock on the vault called name.
+func withVaultLock(name string, fn func() error) error {
+	lock, err := lockVault(name, false)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	return fn()
+}
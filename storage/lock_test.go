@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLockBeingWritten(t *testing.T) {
+	testData(t)
+	path, err := lockPath(activeVault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another session has created the lock but not yet written it
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		data, _ := json.Marshal(LockInfo{PID: os.Getpid(), Host: DeviceName(), Started: time.Now(), Token: "other"})
+		os.WriteFile(path, data, 0600)
+	}()
+
+	_, err = tryLockVault(activeVault, false)
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.Holder.Token != "other" {
+		t.Fatalf("got %v, want the other session's lock", err)
+	}
+}
+
+func TestLockLeftUnwritten(t *testing.T) {
+	testData(t)
+	path, err := lockPath(activeVault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash between creating the lock and writing it
+	if err := os.WriteFile(path, []byte(`{"pid":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-lockGrace)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := tryLockVault(activeVault, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lock.held() {
+		t.Error("the lock file isn't ours")
+	}
+	lock.release()
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+
+	"github.com/electr1fy0/blue/utils"
+)
+
+// MergeNotebooks combines the changes made since base in local and in
+// remote. A note changed on one side only takes that side's version; an
+// edit beats a deletion. A note changed on both sides has its content
+// merged line by line, and if the two edits overlap the remote version is
+// kept as a separate conflict copy. It returns the merged notebook, the
+// number of conflict copies and whether the result differs from remote.
+func MergeNotebooks(base, local, remote *Notebook) (*Notebook, int, bool) {
+	merged := &Notebook{
+		Version: remote.Version,
+		SyncKey: remote.SyncKey,
+		Sync:    remote.Sync,
+		Notes:   make(map[string]*Note),
+		Trash:   make(map[string]*Note),
+	}
+	conflicts, changed := 0, false
+	if !bytes.Equal(local.SyncKey, base.SyncKey) {
+		// set or replaced in this session
+		merged.SyncKey = local.SyncKey
+		changed = !bytes.Equal(local.SyncKey, remote.SyncKey)
+	}
+	if !reflect.DeepEqual(local.Sync, base.Sync) {
+		merged.Sync = local.Sync
+		changed = changed || !reflect.DeepEqual(local.Sync, remote.Sync)
+	}
+	put := func(n *Note) {
+		if n.DeletedAt.IsZero() {
+			merged.Notes[n.ID] = n
+		} else {
+			merged.Trash[n.ID] = n
+		}
+	}
+
+	b, l, r := allNotes(base), allNotes(local), allNotes(remote)
+	ids := make(map[string]bool, len(l)+len(r))
+	for _, m := range []map[string]*Note{b, l, r} {
+		for id := range m {
+			ids[id] = true
+		}
+	}
+
+	for id := range ids {
+		bn, ln, rn := b[id], l[id], r[id]
+		var n *Note
+		switch {
+		case sameVersion(ln, bn):
+			n = rn
+		case sameVersion(rn, bn), sameVersion(ln, rn):
+			n = ln
+		case ln == nil:
+			n = rn
+		case rn == nil:
+			n = ln
+		default:
+			var ok bool
+			if n, ok = mergeNote(bn, ln, rn); !ok {
+				put(conflictCopy(rn))
+				conflicts++
+				n = ln
+			}
+		}
+		if n != nil {
+			put(n)
+		}
+		if !sameVersion(n, rn) {
+			changed = true
+		}
+	}
+	return merged, conflicts, changed || conflicts > 0
+}
+
+// allNotes indexes live and trashed notes together; DeletedAt tells them
+// apart.
+func allNotes(nb *Notebook) map[string]*Note {
+	all := make(map[string]*Note, len(nb.Notes)+len(nb.Trash))
+	for id, n := range nb.Trash {
+		all[id] = n
+	}
+	for id, n := range nb.Notes {
+		all[id] = n
+	}
+	return all
+}
+
+func sameVersion(a, b *Note) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return noteDigest(a) == noteDigest(b)
+}
+
+// mergeNote merges two edits of a note. Fields other than the content go
+// to whichever side changed them, preferring local.
+func mergeNote(base, local, remote *Note) (*Note, bool) {
+	var bn Note
+	if base != nil {
+		bn = *base
+	}
+	content, ok := utils.Merge3(bn.Content, local.Content, remote.Content)
+	if !ok {
+		return nil, false
+	}
+	n := *local
+	n.Revisions = slices.Clone(local.Revisions)
+	if local.Title == bn.Title {
+		n.Title = remote.Title
+	}
+	if local.DeletedAt.Equal(bn.DeletedAt) {
+		n.DeletedAt = remote.DeletedAt
+	}
+	n.SetContent(content)
+	return &n, true
+}
+
+// conflictCopy turns the remote side of a conflict into a note of its own.
+func conflictCopy(remote *Note) *Note {
+	c := *remote
+	c.ID = NewID()
+	c.Title = remote.Title + " (conflict"
+	if remote.UpdatedBy != "" {
+		c.Title += " from " + remote.UpdatedBy
+	}
+	c.Title += ")"
+	c.Revisions = nil
+	c.ConflictOf = remote.ID
+	return &c
+}
+
+// MergeRemote combines a note changed both here and on another device
+// since base, the version both last had from the sync server. If one
+// version grew out of the other it is kept; otherwise the edits are merged
+// line by line from base. Without a base, the newest version in both
+// revision histories stands in for it. It returns the note to keep and, if
+// the edits overlap, the remote version as a conflict copy to keep beside
+// it.
+func MergeRemote(base, local, remote *Note) (*Note, *Note) {
+	switch {
+	case hasVersion(remote, local):
+		return remote, nil
+	case hasVersion(local, remote):
+		return local, nil
+	}
+	if base == nil {
+		base = commonVersion(local, remote)
+	}
+	if base != nil {
+		if n, ok := mergeNote(base, local, remote); ok {
+			return n, nil
+		}
+	}
+	return local, conflictCopy(remote)
+}
+
+// hasVersion reports whether the current version of other is in the
+// history of n.
+func hasVersion(n, other *Note) bool {
+	return slices.ContainsFunc(n.Revisions, func(r Revision) bool {
+		return r.Content == other.Content && r.UpdatedAt.Equal(other.UpdatedAt)
+	})
+}
+
+// commonVersion returns the newest revision in the histories of both a and
+// b as a note, or nil if they share none.
+func commonVersion(a, b *Note) *Note {
+	for i := len(a.Revisions) - 1; i >= 0; i-- {
+		r := a.Revisions[i]
+		if slices.ContainsFunc(b.Revisions, func(o Revision) bool {
+			return o.Content == r.Content && o.UpdatedAt.Equal(r.UpdatedAt)
+		}) {
+			return &Note{ID: a.ID, Title: ExtractTitle(r.Content), Content: r.Content, UpdatedAt: r.UpdatedAt}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import "testing"
+
+func TestMergeNotebooks(t *testing.T) {
+	const content = "# n\na\nb\nc\n"
+	edit := func(to string) func(*Notebook) {
+		return func(nb *Notebook) { nb.Notes["n"].SetContent(to) }
+	}
+	trash := func(nb *Notebook) { nb.DeleteNote("n") }
+	purge := func(nb *Notebook) { nb.DeleteNote("n"); nb.PurgeNote("n") }
+	keep := func(*Notebook) {}
+
+	tests := []struct {
+		name          string
+		local, remote func(*Notebook)
+		want          string // content of n; "" if it is gone
+		trashed       bool
+		conflicts     int
+		changed       bool // from remote
+	}{
+		{"untouched", keep, keep, content, false, 0, false},
+		{"local edit", edit("# n\nA\nb\nc\n"), keep, "# n\nA\nb\nc\n", false, 0, true},
+		{"remote edit", keep, edit("# n\na\nb\nC\n"), "# n\na\nb\nC\n", false, 0, false},
+		{"same edit", edit("# n\nA\nb\nc\n"), edit("# n\nA\nb\nc\n"), "# n\nA\nb\nc\n", false, 0, true},
+		{"edits apart", edit("# n\nA\nb\nc\n"), edit("# n\na\nb\nC\n"), "# n\nA\nb\nC\n", false, 0, true},
+		{"adjacent edits", edit("# n\nA\nb\nc\n"), edit("# n\na\nB\nc\n"), "# n\nA\nB\nc\n", false, 0, true},
+		{"overlapping edits", edit("# n\nA\nb\nc\n"), edit("# n\nα\nb\nc\n"), "# n\nA\nb\nc\n", false, 1, true},
+		{"local trash", trash, keep, content, true, 0, true},
+		{"trash and edit", trash, edit("# n\na\nb\nC\n"), "# n\na\nb\nC\n", true, 0, true},
+		{"edit and trash", edit("# n\nA\nb\nc\n"), trash, "# n\nA\nb\nc\n", true, 0, true},
+		{"local purge", purge, keep, "", false, 0, true},
+		{"remote purge", keep, purge, "", false, 0, false},
+		{"purge and edit", purge, edit("# n\na\nb\nC\n"), "# n\na\nb\nC\n", false, 0, false},
+		{"edit and purge", edit("# n\nA\nb\nc\n"), purge, "# n\nA\nb\nc\n", false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := NewNotebook()
+			base.AddNote(&Note{ID: "n", Title: "n", Content: content})
+			local, remote := base.Clone(), base.Clone()
+			tt.local(local)
+			tt.remote(remote)
+
+			merged, conflicts, changed := MergeNotebooks(base, local, remote)
+			if conflicts != tt.conflicts || changed != tt.changed {
+				t.Errorf("conflicts, changed = %d, %v; want %d, %v", conflicts, changed, tt.conflicts, tt.changed)
+			}
+			n, trashed := merged.Notes["n"], merged.Trash["n"] != nil
+			if trashed {
+				n = merged.Trash["n"]
+			}
+			switch {
+			case tt.want == "":
+				if n != nil {
+					t.Errorf("n kept: %+v", n)
+				}
+			case n == nil:
+				t.Error("n is gone")
+			case trashed != tt.trashed:
+				t.Errorf("trashed = %v, want %v", trashed, tt.trashed)
+			case n.Content != tt.want:
+				t.Errorf("content %q, want %q", n.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeNotebooksConflictCopy(t *testing.T) {
+	base := NewNotebook()
+	base.AddNote(&Note{ID: "n", Title: "n", Content: "# n\na\n"})
+	local, remote := base.Clone(), base.Clone()
+	local.Notes["n"].SetContent("# n\nmine\n")
+	remote.Notes["n"].SetContent("# n\ntheirs\n")
+	remote.Notes["n"].UpdatedBy = "laptop"
+
+	merged, conflicts, _ := MergeNotebooks(base, local, remote)
+	if conflicts != 1 || len(merged.Notes) != 2 {
+		t.Fatalf("got %d conflicts and %d notes, want one copy beside n", conflicts, len(merged.Notes))
+	}
+	if merged.Notes["n"].Content != "# n\nmine\n" {
+		t.Errorf("n has %q, want the local version", merged.Notes["n"].Content)
+	}
+	for id, c := range merged.Notes {
+		if id == "n" {
+			continue
+		}
+		if c.ConflictOf != "n" || c.Content != "# n\ntheirs\n" || c.Title != "n (conflict from laptop)" || c.Revisions != nil {
+			t.Errorf("unexpected conflict copy %+v", c)
+		}
+	}
+}
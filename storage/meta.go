@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meta is the front matter block at the top of a note's content.
+type Meta struct {
+	Tags     []string
+	Pinned   bool
+	Favorite bool
+	Archived bool
+}
+
+// ParseFrontMatter splits a note into its front matter and body. Content
+// without front matter yields the zero Meta and the content unchanged.
+func ParseFrontMatter(content string) (Meta, string) {
+	meta := Meta{}
+	trim := strings.TrimLeft(content, "\n\r\t ")
+	if !strings.HasPrefix(trim, "---") {
+		// no front matter
+		return meta, content
+	}
+	// find the closing '---' on its own line
+	// search for "\n---" after the first line
+	// handle case like "---\nkey: val\n---\n"
+	rest := trim[3:]
+	idx := strings.Index(rest, "---")
+	if idx == -1 {
+		// malformed - treat as no front matter
+		return meta, content
+	}
+
+	metaBlock := rest[:idx]
+	// body starts after the closing '---'
+	body := strings.TrimLeft(rest[idx+3:], "\n\r")
+
+	// parse lines like "tags: a,b" or "pinned: true"
+	lines := strings.SplitSeq(metaBlock, "\n")
+	for ln := range lines {
+		ln = strings.TrimSpace(ln)
+		if ln == "" {
+			continue
+		}
+		parts := strings.SplitN(ln, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		k := strings.TrimSpace(strings.ToLower(parts[0]))
+		v := strings.TrimSpace(parts[1])
+		switch k {
+		case "tags":
+			v = strings.Trim(v, "[] ")
+			if v == "" {
+				meta.Tags = []string{}
+			} else if strings.Contains(v, ",") {
+				ps := strings.Split(v, ",")
+				for i := range ps {
+					ps[i] = strings.TrimSpace(ps[i])
+				}
+				meta.Tags = ps
+			} else {
+				// split by spaces
+				ps := strings.Fields(v)
+				meta.Tags = ps
+			}
+		case "pinned":
+			b, _ := strconv.ParseBool(v)
+			meta.Pinned = b
+		case "favorite", "favorited":
+			b, _ := strconv.ParseBool(v)
+			meta.Favorite = b
+		case "archived":
+			b, _ := strconv.ParseBool(v)
+			meta.Archived = b
+		default:
+			// ignore unknown keys
+		}
+	}
+	return meta, body
+}
+
+// BuildContentWithMeta prefixes body with front matter for meta.
+func BuildContentWithMeta(meta Meta, body string) string {
+	lines := []string{"---"}
+	if len(meta.Tags) > 0 {
+		lines = append(lines, "tags: "+strings.Join(meta.Tags, ","))
+	} else {
+		lines = append(lines, "tags: ")
+	}
+	lines = append(lines, fmt.Sprintf("pinned: %t", meta.Pinned))
+	lines = append(lines, fmt.Sprintf("favorite: %t", meta.Favorite))
+	lines = append(lines, fmt.Sprintf("archived: %t", meta.Archived))
+	lines = append(lines, "---", "", body)
+	return strings.Join(lines, "\n")
+}
+
+// ExtractTitle derives a note title from its first heading or line.
+func ExtractTitle(content string) string {
+	// strip front matter
+	_, body := ParseFrontMatter(content)
+	lines := strings.Split(body, "\n")
+	for _, line := range lines {
+		trim := strings.TrimSpace(line)
+		if trim == "" {
+			continue
+		}
+		// Remove markdown heading prefix
+		if strings.HasPrefix(trim, "# ") {
+			return strings.TrimSpace(trim[2:])
+		}
+		// Use first non-empty line as title, truncated if too long
+		if len(trim) > 50 {
+			return trim[:47] + "..."
+		}
+		return trim
+	}
+	return fmt.Sprintf("Note_%d", time.Now().Unix())
+}
+
+// Matches reports whether term occurs in the note's title or body,
+// ignoring case.
+func (n *Note) Matches(term string) bool {
+	term = strings.ToLower(term)
+	if strings.Contains(strings.ToLower(n.Title), term) {
+		return true
+	}
+	_, body := ParseFrontMatter(n.Content)
+	return strings.Contains(strings.ToLower(body), term)
+}
@@ -1,59 +1,243 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
-
-	"github.com/electr1fy0/blue/crypto"
 )
 
+// currentVersion is the on-disk notebook format. Version 1 keyed notes by
+// title; version 2 keys them by a permanent random ID.
+const currentVersion = 2
+
 type Note struct {
-	Title     string    `json:"title"`
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	UpdatedBy string     `json:"updated_by,omitempty"` // device that made the current version
+	Revisions []Revision `json:"revisions,omitempty"`  // oldest first
+	DeletedAt time.Time  `json:"deleted_at,omitzero"`  // set while the note is in the trash
+	// ConflictOf is set on a conflict copy: the ID of the note it is
+	// another version of.
+	ConflictOf string `json:"conflict_of,omitempty"`
+}
+
+// Revision is an earlier version of a note's content.
+type Revision struct {
 	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Device    string    `json:"device,omitempty"`
+}
+
+// MaxRevisions is how many earlier versions each note keeps. Zero disables
+// history.
+var MaxRevisions = 50
+
+// DeviceName identifies this machine in revision history.
+func DeviceName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	return host
+}
+
+// SetContent replaces the note's content, keeping the previous version in
+// Revisions. It is a no-op if content is unchanged.
+func (n *Note) SetContent(content string) {
+	if content == n.Content {
+		return
+	}
+	if MaxRevisions > 0 {
+		n.Revisions = append(n.Revisions, Revision{
+			Content:   n.Content,
+			UpdatedAt: n.UpdatedAt,
+			Device:    n.UpdatedBy,
+		})
+		if extra := len(n.Revisions) - MaxRevisions; extra > 0 {
+			n.Revisions = append([]Revision(nil), n.Revisions[extra:]...)
+		}
+	}
+	n.Content = content
+	n.UpdatedAt = time.Now()
+	n.UpdatedBy = DeviceName()
 }
 
 type Notebook struct {
 	Version int              `json:"version"`
 	Notes   map[string]*Note `json:"notes"`
+	Trash   map[string]*Note `json:"trash,omitempty"`
+	// SyncKey encrypts notes sent to the sync server. Every device syncing
+	// the vault needs the same one.
+	SyncKey []byte `json:"sync_key,omitempty"`
+	// Sync tracks what was last exchanged with the sync server.
+	Sync *SyncState `json:"sync,omitempty"`
 }
 
+// TrashRetention is how long deleted notes stay in the trash before being
+// purged on load. Zero keeps them forever.
+var TrashRetention = 30 * 24 * time.Hour
+
 func NewNotebook() *Notebook {
 	return &Notebook{
-		Version: 1,
+		Version: currentVersion,
 		Notes:   make(map[string]*Note),
+		Trash:   make(map[string]*Note),
+	}
+}
+
+// NewID returns a random RFC 4122 version 4 UUID.
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
 	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
 }
 
 func (nb *Notebook) AddNote(note *Note) {
+	if note.ID == "" {
+		note.ID = NewID()
+	}
 	now := time.Now()
 	note.CreatedAt = now
 	note.UpdatedAt = now
-	nb.Notes[note.Title] = note
+	if note.UpdatedBy == "" {
+		note.UpdatedBy = DeviceName()
+	}
+	nb.Notes[note.ID] = note
 }
 
-func (nb *Notebook) GetNote(title string) (*Note, bool) {
-	note, exists := nb.Notes[title]
+func (nb *Notebook) GetNote(id string) (*Note, bool) {
+	note, exists := nb.Notes[id]
 	return note, exists
 }
 
-func (nb *Notebook) DeleteNote(title string) bool {
-	if _, exists := nb.Notes[title]; exists {
-		delete(nb.Notes, title)
+// DeleteNote moves a note to the trash.
+func (nb *Notebook) DeleteNote(id string) bool {
+	note, exists := nb.Notes[id]
+	if !exists {
+		return false
+	}
+	delete(nb.Notes, id)
+	note.DeletedAt = time.Now()
+	nb.Trash[id] = note
+	return true
+}
+
+// RestoreNote moves a note from the trash back into the notebook.
+func (nb *Notebook) RestoreNote(id string) (*Note, bool) {
+	note, exists := nb.Trash[id]
+	if !exists {
+		return nil, false
+	}
+	delete(nb.Trash, id)
+	note.DeletedAt = time.Time{}
+	nb.Notes[id] = note
+	return note, true
+}
+
+// PurgeNote permanently removes a note from the trash.
+func (nb *Notebook) PurgeNote(id string) bool {
+	if _, exists := nb.Trash[id]; exists {
+		delete(nb.Trash, id)
 		return true
 	}
 	return false
 }
 
+// EmptyTrash permanently removes every trashed note and returns how many
+// there were.
+func (nb *Notebook) EmptyTrash() int {
+	n := len(nb.Trash)
+	nb.Trash = make(map[string]*Note)
+	return n
+}
+
+// purgeExpiredTrash drops notes that have been in the trash longer than
+// TrashRetention.
+func (nb *Notebook) purgeExpiredTrash() int {
+	if TrashRetention <= 0 {
+		return 0
+	}
+	cutoff := time.Now().Add(-TrashRetention)
+	purged := 0
+	for id, note := range nb.Trash {
+		if note.DeletedAt.Before(cutoff) {
+			delete(nb.Trash, id)
+			purged++
+		}
+	}
+	return purged
+}
+
+// Clone returns a copy that can be saved in the background while nb keeps
+// changing. Notes are copied but share their Revisions arrays, which is
+// safe because SetContent only appends past a copy's length or reallocates.
+func (nb *Notebook) Clone() *Notebook {
+	c := &Notebook{
+		Version: nb.Version,
+		SyncKey: slices.Clone(nb.SyncKey),
+		Sync:    nb.Sync.clone(),
+		Notes:   make(map[string]*Note, len(nb.Notes)),
+		Trash:   make(map[string]*Note, len(nb.Trash)),
+	}
+	for id, n := range nb.Notes {
+		nn := *n
+		c.Notes[id] = &nn
+	}
+	for id, n := range nb.Trash {
+		nn := *n
+		c.Trash[id] = &nn
+	}
+	return c
+}
+
 func (nb *Notebook) ListNotes() []string {
-	titles := make([]string, 0, len(nb.Notes))
-	for title := range nb.Notes {
-		titles = append(titles, title)
+	ids := make([]string, 0, len(nb.Notes))
+	for id := range nb.Notes {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// migrate upgrades an older notebook in place to currentVersion and reports
+// whether anything changed.
+func (nb *Notebook) migrate() bool {
+	if nb.Notes == nil {
+		nb.Notes = make(map[string]*Note)
+	}
+	if nb.Trash == nil {
+		nb.Trash = make(map[string]*Note)
+	}
+	if nb.Version < 2 {
+		// version 1 keyed notes by title; assign IDs and rekey
+		rekeyed := make(map[string]*Note, len(nb.Notes))
+		for title, note := range nb.Notes {
+			if note.Title == "" {
+				note.Title = title
+			}
+			if note.ID == "" {
+				note.ID = NewID()
+			}
+			rekeyed[note.ID] = note
+		}
+		nb.Notes = rekeyed
 	}
-	return titles
+	if nb.Version == currentVersion {
+		return false
+	}
+	nb.Version = currentVersion
+	return true
 }
 
 func (nb *Notebook) ToJSON() ([]byte, error) {
@@ -66,68 +250,62 @@ func FromJSON(data []byte) (*Notebook, error) {
 	if err != nil {
 		return nil, err
 	}
+	nb.migrate()
 	return &nb, nil
 }
 
+// GetNotebookPath returns the file or directory of the active vault.
 func GetNotebookPath() (string, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
-	}
-	return filepath.Join(homeDir, ".blue-vault"), nil
+	return VaultPath(activeVault)
 }
 
 func NotebookExists() (bool, error) {
-	path, err := GetNotebookPath()
-	if err != nil {
-		return false, err
-	}
-	_, err = os.Stat(path)
-	if err == nil {
-		return true, nil
-	}
-	if os.IsNotExist(err) {
-		return false, nil
-	}
-	return false, err
+	return VaultExists(activeVault)
 }
 
-func SaveNotebook(nb *Notebook, password string) error {
-	jsonData, err := nb.ToJSON()
+// writeFileAtomic writes data to a temp file next to path, syncs it and
+// renames it into place, so a crash leaves either the old or the new file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	encryptedData, err := crypto.Encrypt(jsonData, password)
-	if err != nil {
+	tmpName := tmp.Name()
+	defer func() {
+		// no-op once the rename succeeded
+		_ = os.Remove(tmpName)
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
 		return err
 	}
-	encryptedJSON, err := json.Marshal(encryptedData)
-	if err != nil {
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
 		return err
 	}
-	path, err := GetNotebookPath()
-	if err != nil {
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
 		return err
 	}
-	return os.WriteFile(path, encryptedJSON, 0600)
-}
-
-func LoadNotebook(password string) (*Notebook, error) {
-	path, err := GetNotebookPath()
-	if err != nil {
-		return nil, err
-	}
-	encryptedJSON, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-	var encryptedData crypto.EncryptedData
-	if err := json.Unmarshal(encryptedJSON, &encryptedData); err != nil {
-		return nil, err
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
 	}
-	jsonData, err := crypto.Decrypt(encryptedData, password)
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes a directory entry after a rename. Not every platform
+// supports it, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
 	if err != nil {
-		return nil, err
+		return
 	}
-	return FromJSON(jsonData)
+	_ = d.Sync()
+	_ = d.Close()
 }
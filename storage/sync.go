@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+
+	"github.com/electr1fy0/blue/crypto"
+)
+
+const (
+	syncKeyBytes = 32 // AES-256, as crypto.GenerateKey makes
+	gcmNonceSize = 12 // as crypto.Seal uses
+)
+
+var syncKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnsureSyncKey gives nb a sync key if it has none yet, and reports whether
+// it made one.
+func (nb *Notebook) EnsureSyncKey() (bool, error) {
+	if len(nb.SyncKey) != 0 {
+		return false, nil
+	}
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return false, err
+	}
+	nb.SyncKey = key
+	return true, nil
+}
+
+// FormatSyncKey writes a sync key in the same dash-separated groups as a
+// recovery key, for copying to another device.
+func FormatSyncKey(key []byte) string {
+	return groupKey(syncKeyEncoding.EncodeToString(key))
+}
+
+// ParseSyncKey reads a key written by FormatSyncKey.
+func ParseSyncKey(s string) ([]byte, error) {
+	key, err := syncKeyEncoding.DecodeString(normalizeRecoveryKey(s))
+	if err != nil || len(key) != syncKeyBytes {
+		return nil, fmt.Errorf("not a valid sync key")
+	}
+	return key, nil
+}
+
+// SealNote encrypts n with the sync key, for the sync server. The server
+// only ever sees the result, which is the nonce followed by the ciphertext.
+func SealNote(n *Note, key []byte) ([]byte, error) {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(data)
+	enc, err := crypto.Seal(data, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(enc.Nonce, enc.Ciphertext...), nil
+}
+
+// OpenNote decrypts a note sealed by SealNote, checking that it is the note
+// the server filed it under.
+func OpenNote(id string, blob, key []byte) (*Note, error) {
+	if len(blob) < gcmNonceSize {
+		return nil, fmt.Errorf("note %s: blob too short", id)
+	}
+	data, err := crypto.Open(crypto.EncryptedData{
+		Nonce:      blob[:gcmNonceSize],
+		Ciphertext: blob[gcmNonceSize:],
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("note %s: can't decrypt with this vault's sync key", id)
+	}
+	defer crypto.Wipe(data)
+	var n Note
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("note %s: %w", id, err)
+	}
+	if n.ID != id {
+		return nil, fmt.Errorf("note %s: blob holds note %s", id, n.ID)
+	}
+	return &n, nil
+}
+
+// SyncState is what the vault last heard from its sync server: how far it
+// has read the server's changes, and each note as the server has it.
+type SyncState struct {
+	Server string                `json:"server"` // URL the rest belongs to
+	Seq    int64                 `json:"seq"`    // last change seen
+	Notes  map[string]SyncedNote `json:"notes,omitempty"`
+}
+
+// SyncedNote is a note as the sync server last had it. An empty Digest
+// means the server has it deleted.
+type SyncedNote struct {
+	Version int64  `json:"version"`
+	Digest  string `json:"digest"`
+	// Base is the note at Version, without its history, to merge edits
+	// made here and elsewhere from. States from older versions lack it.
+	Base *Note `json:"base,omitempty"`
+}
+
+// SyncFor returns the vault's sync state for the server at url, starting
+// over if it last synced with another server.
+func (nb *Notebook) SyncFor(url string) *SyncState {
+	if nb.Sync == nil || nb.Sync.Server != url {
+		nb.Sync = &SyncState{Server: url}
+	}
+	if nb.Sync.Notes == nil {
+		nb.Sync.Notes = make(map[string]SyncedNote)
+	}
+	return nb.Sync
+}
+
+// Synced reports whether n is unchanged since the server last had it.
+func (s *SyncState) Synced(n *Note) bool {
+	sn, ok := s.Notes[n.ID]
+	return ok && sn.Digest == NoteDigest(n)
+}
+
+// Record notes that the server has n, at version.
+func (s *SyncState) Record(n *Note, version int64) {
+	base := *n
+	base.Revisions = nil
+	s.Notes[n.ID] = SyncedNote{Version: version, Digest: NoteDigest(n), Base: &base}
+}
+
+// RecordDeleted notes that the server deleted the note id, at version.
+func (s *SyncState) RecordDeleted(id string, version int64) {
+	s.Notes[id] = SyncedNote{Version: version}
+}
+
+// Saw moves the state past the change numbered seq.
+func (s *SyncState) Saw(seq int64) {
+	s.Seq = max(s.Seq, seq)
+}
+
+func (s *SyncState) clone() *SyncState {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.Notes = maps.Clone(s.Notes)
+	return &c
+}
+
+// NoteDigest identifies a version of a note, as noteDigest does, in hex.
+func NoteDigest(n *Note) string {
+	d := noteDigest(n)
+	return hex.EncodeToString(d[:])
+}
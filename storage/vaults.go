@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultVaultName = "default"
+
+	vaultExt    = ".vault"
+	vaultDirExt = ".vaultdir"
+	settingsExt = ".settings.json"
+)
+
+var (
+	activeVault = DefaultVaultName
+
+	vaultNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+)
+
+// VaultSettings holds per-vault options that are not secret and are kept
+// next to the vault in plain JSON.
+type VaultSettings struct {
+	SyncURL      string `json:"sync_url,omitempty"`
+	SyncToken    string `json:"sync_token,omitempty"`
+	SyncDisabled bool   `json:"sync_disabled,omitempty"`
+}
+
+// SetVault selects the vault used by the rest of the package.
+func SetVault(name string) error {
+	if err := ValidateVaultName(name); err != nil {
+		return err
+	}
+	activeVault = name
+	return nil
+}
+
+// CurrentVault returns the name of the active vault.
+func CurrentVault() string {
+	return activeVault
+}
+
+func ValidateVaultName(name string) error {
+	if !vaultNameRe.MatchString(name) {
+		return fmt.Errorf("invalid vault name %q: use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// DataDir is where vaults live: $XDG_DATA_HOME/blue/vaults, defaulting to
+// ~/.local/share/blue/vaults.
+func DataDir() (string, error) {
+	base := os.Getenv("XDG_DATA_HOME")
+	if base == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(homeDir, ".local", "share")
+	}
+	dir := filepath.Join(base, "blue", "vaults")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// VaultPath returns where the vault called name is stored, or where it
+// would be created in DefaultLayout.
+func VaultPath(name string) (string, error) {
+	path, _, _, err := vaultLocation(name)
+	return path, err
+}
+
+// vaultLocation finds the vault called name in either layout.
+func vaultLocation(name string) (path, layout string, exists bool, err error) {
+	for _, layout := range []string{LayoutFile, LayoutDir} {
+		path, err := layoutPath(name, layout)
+		if err != nil {
+			return "", "", false, err
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path, layout, true, nil
+		} else if !os.IsNotExist(err) {
+			return "", "", false, err
+		}
+	}
+	path, err = layoutPath(name, DefaultLayout)
+	return path, DefaultLayout, false, err
+}
+
+func layoutPath(name, layout string) (string, error) {
+	if err := ValidateVaultName(name); err != nil {
+		return "", err
+	}
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	if layout == LayoutDir {
+		return filepath.Join(dir, name+vaultDirExt), nil
+	}
+	return filepath.Join(dir, name+vaultExt), nil
+}
+
+func settingsPath(name string) (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+settingsExt), nil
+}
+
+// VaultExists reports whether a vault called name has been created.
+func VaultExists(name string) (bool, error) {
+	_, _, exists, err := vaultLocation(name)
+	return exists, err
+}
+
+// ListVaults returns the names of all vaults, sorted.
+func ListVaults() ([]string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, ext := range []string{vaultExt, vaultDirExt} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range matches {
+			name := strings.TrimSuffix(filepath.Base(p), ext)
+			if ValidateVaultName(name) == nil && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// vaultFiles returns the vault file, its settings and its backups.
+func vaultFiles(name string) ([]string, error) {
+	path, err := VaultPath(name)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := settingsPath(name)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := filepath.Glob(path + ".bak.*")
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{path, settings}, backups...), nil
+}
+
+// RenameVault renames a vault along with its settings and backups.
+func RenameVault(oldName, newName string) error {
+	if err := ValidateVaultName(newName); err != nil {
+		return err
+	}
+	if exists, err := VaultExists(oldName); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("vault %q does not exist", oldName)
+	}
+	if exists, err := VaultExists(newName); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("vault %q already exists", newName)
+	}
+
+	err := withVaultLock(oldName, func() error {
+		files, err := vaultFiles(oldName)
+		if err != nil {
+			return err
+		}
+		dir, err := DataDir()
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			rest := strings.TrimPrefix(filepath.Base(f), oldName)
+			if err := os.Rename(f, filepath.Join(dir, newName+rest)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if activeVault == oldName {
+		activeVault = newName
+	}
+	return nil
+}
+
+// DeleteVault removes a vault, its settings and its backups.
+func DeleteVault(name string) error {
+	if exists, err := VaultExists(name); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+	return withVaultLock(name, func() error {
+		files, err := vaultFiles(name)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := os.RemoveAll(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoadVaultSettings reads a vault's settings; a missing file yields the
+// zero value.
+func LoadVaultSettings(name string) (VaultSettings, error) {
+	var vs VaultSettings
+	path, err := settingsPath(name)
+	if err != nil {
+		return vs, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return vs, nil
+	}
+	if err != nil {
+		return vs, err
+	}
+	if err := json.Unmarshal(data, &vs); err != nil {
+		return vs, fmt.Errorf("%s: %w", path, err)
+	}
+	return vs, nil
+}
+
+func SaveVaultSettings(name string, vs VaultSettings) error {
+	path, err := settingsPath(name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(vs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// MigrateLegacyVault moves a pre-XDG ~/.blue-vault (and its backups) into
+// the data directory as the default vault. It does nothing if the default
+// vault already exists.
+func MigrateLegacyVault() error {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	legacy := filepath.Join(homeDir, ".blue-vault")
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if exists, err := VaultExists(DefaultVaultName); err != nil || exists {
+		return err
+	}
+
+	target, err := layoutPath(DefaultVaultName, LayoutFile)
+	if err != nil {
+		return err
+	}
+	backups, err := filepath.Glob(legacy + ".bak.*")
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		rest := strings.TrimPrefix(b, legacy)
+		if err := moveFile(b, target+rest); err != nil {
+			return err
+		}
+	}
+	return moveFile(legacy, target)
+}
+
+// moveFile renames src to dst, copying when they are on different
+// filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(dst, data, 0600); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DiffOp int
+
+const (
+	DiffEqual DiffOp = iota
+	DiffInsert
+	DiffDelete
+	DiffHunk // hunk header, e.g. "@@ -1,3 +1,4 @@"
+)
+
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// DiffLines returns a shortest edit script turning a into b (Myers).
+func DiffLines(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	out := make([]DiffLine, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[max+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			out = append(out, DiffLine{DiffEqual, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			out = append(out, DiffLine{DiffInsert, b[y-1]})
+			y--
+		} else {
+			out = append(out, DiffLine{DiffDelete, a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		out = append(out, DiffLine{DiffEqual, a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// UnifiedDiff diffs two texts line by line and groups the changes into
+// hunks with the given number of context lines.
+func UnifiedDiff(a, b string, context int) []DiffLine {
+	lines := DiffLines(splitLines(a), splitLines(b))
+
+	var out []DiffLine
+	for i := 0; i < len(lines); {
+		if lines[i].Op == DiffEqual {
+			i++
+			continue
+		}
+		// extend the hunk while changes are within 2*context of each other
+		start := max(i-context, 0)
+		end := i
+		for end < len(lines) {
+			if lines[end].Op != DiffEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].Op == DiffEqual {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				end = min(end+context, len(lines))
+				break
+			}
+			end = run
+		}
+
+		aStart, bStart := 1, 1
+		for _, l := range lines[:start] {
+			if l.Op != DiffInsert {
+				aStart++
+			}
+			if l.Op != DiffDelete {
+				bStart++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, l := range lines[start:end] {
+			if l.Op != DiffInsert {
+				aLen++
+			}
+			if l.Op != DiffDelete {
+				bLen++
+			}
+		}
+		// an empty side is numbered from the line before it, as in diff -u
+		if aLen == 0 {
+			aStart--
+		}
+		if bLen == 0 {
+			bStart--
+		}
+		out = append(out, DiffLine{DiffHunk, fmt.Sprintf("@@ -%d,%d +%d,%d @@", aStart, aLen, bStart, bLen)})
+		out = append(out, lines[start:end]...)
+		i = end
+	}
+	return out
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
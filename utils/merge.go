@@ -0,0 +1,120 @@
+package utils
+
+import "strings"
+
+// edit replaces base[start:end] with lines.
+type edit struct {
+	start, end int
+	lines      []string
+}
+
+// edits turns the diff from base to other into replacements of base ranges.
+func edits(base, other []string) []edit {
+	var out []edit
+	var cur *edit
+	i := 0
+	for _, l := range DiffLines(base, other) {
+		if l.Op == DiffEqual {
+			if cur != nil {
+				out = append(out, *cur)
+				cur = nil
+			}
+			i++
+			continue
+		}
+		if cur == nil {
+			cur = &edit{start: i, end: i}
+		}
+		if l.Op == DiffDelete {
+			cur.end++
+			i++
+		} else {
+			cur.lines = append(cur.lines, l.Text)
+		}
+	}
+	if cur != nil {
+		out = append(out, *cur)
+	}
+	return out
+}
+
+// apply returns base[start:end] with the given edits, which must lie inside
+// that range, applied.
+func apply(base []string, start, end int, es []edit) []string {
+	var out []string
+	pos := start
+	for _, e := range es {
+		out = append(out, base[pos:e.start]...)
+		out = append(out, e.lines...)
+		pos = e.end
+	}
+	return append(out, base[pos:end]...)
+}
+
+// Merge3 merges the changes from base to ours and from base to theirs line
+// by line. It reports false if both sides changed the same lines
+// differently.
+func Merge3(base, ours, theirs string) (string, bool) {
+	if ours == theirs || theirs == base {
+		return ours, true
+	}
+	if ours == base {
+		return theirs, true
+	}
+	b := splitLines(base)
+	a, c := edits(b, splitLines(ours)), edits(b, splitLines(theirs))
+
+	var out []string
+	pos := 0
+	for len(a) > 0 || len(c) > 0 {
+		// start a group at the earliest edit and pull in everything from
+		// either side that touches it
+		start := len(b) + 1
+		if len(a) > 0 {
+			start = a[0].start
+		}
+		if len(c) > 0 && c[0].start < start {
+			start = c[0].start
+		}
+		end := start
+		var ga, gc []edit
+		for {
+			grew := false
+			if len(a) > 0 && (a[0].start < end || a[0].start == start) {
+				end = max(end, a[0].end)
+				ga, a = append(ga, a[0]), a[1:]
+				grew = true
+			}
+			if len(c) > 0 && (c[0].start < end || c[0].start == start) {
+				end = max(end, c[0].end)
+				gc, c = append(gc, c[0]), c[1:]
+				grew = true
+			}
+			if !grew {
+				break
+			}
+		}
+
+		out = append(out, b[pos:start]...)
+		switch {
+		case len(gc) == 0:
+			out = append(out, apply(b, start, end, ga)...)
+		case len(ga) == 0:
+			out = append(out, apply(b, start, end, gc)...)
+		default:
+			x, y := apply(b, start, end, ga), apply(b, start, end, gc)
+			if strings.Join(x, "\n") != strings.Join(y, "\n") || len(x) != len(y) {
+				return "", false
+			}
+			out = append(out, x...)
+		}
+		pos = end
+	}
+	out = append(out, b[pos:]...)
+
+	merged := strings.Join(out, "\n")
+	if len(out) > 0 && strings.HasSuffix(ours, "\n") {
+		merged += "\n"
+	}
+	return merged, true
+}
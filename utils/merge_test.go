@@ -0,0 +1,53 @@
+package utils
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	const base = "a\nb\nc\nd\n"
+	tests := []struct {
+		name         string
+		ours, theirs string
+		want         string
+		ok           bool
+	}{
+		{"unchanged", base, base, base, true},
+		{"ours only", "a\nB\nc\nd\n", base, "a\nB\nc\nd\n", true},
+		{"theirs only", base, "a\nb\nC\nd\n", "a\nb\nC\nd\n", true},
+		{"same edit", "a\nB\nc\nd\n", "a\nB\nc\nd\n", "a\nB\nc\nd\n", true},
+		{"apart", "A\nb\nc\nd\n", "a\nb\nc\nD\n", "A\nb\nc\nD\n", true},
+		{"adjacent", "a\nB\nc\nd\n", "a\nb\nC\nd\n", "a\nB\nC\nd\n", true},
+		{"insert and edit", "a\nx\nb\nc\nd\n", "a\nb\nc\nD\n", "a\nx\nb\nc\nD\n", true},
+		{"delete and edit elsewhere", "a\nc\nd\n", "a\nb\nc\nD\n", "a\nc\nD\n", true},
+		{"same delete", "a\nc\nd\n", "a\nc\nd\n", "a\nc\nd\n", true},
+		{"overlap", "a\nB\nc\nd\n", "a\nβ\nc\nd\n", "", false},
+		{"overlap across lines", "a\nB\nC\nd\n", "a\nb\nγ\nd\n", "", false},
+		{"delete and edit", "a\nc\nd\n", "a\nβ\nc\nd\n", "", false},
+		{"inserts at one place", "a\nx\nb\nc\nd\n", "a\ny\nb\nc\nd\n", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Merge3(base, tt.ours, tt.theirs)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("Merge3 = %q, %v; want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMerge3TrailingNewline(t *testing.T) {
+	if got, _ := Merge3("a\nb", "A\nb", "a\nB"); got != "A\nB" {
+		t.Errorf("without a newline: got %q", got)
+	}
+	if got, _ := Merge3("a\nb\n", "A\nb\n", "a\nB\n"); got != "A\nB\n" {
+		t.Errorf("with a newline: got %q", got)
+	}
+}
+
+func TestMerge3FromEmpty(t *testing.T) {
+	if _, ok := Merge3("", "x\n", "y\n"); ok {
+		t.Error("two different notes written from nothing merged")
+	}
+	if got, ok := Merge3("", "x\n", ""); !ok || got != "x\n" {
+		t.Errorf("got %q, %v; want x", got, ok)
+	}
+}
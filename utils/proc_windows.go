@@ -0,0 +1,14 @@
+package utils
+
+import "os"
+
+// ProcessAlive reports whether a process with the given PID exists. On
+// Windows FindProcess opens a handle, which fails once the process is gone.
+func ProcessAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
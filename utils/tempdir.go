@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RequireRAM refuses to write temporary files, which hold decrypted notes,
+// unless a RAM-backed directory is available for them.
+var RequireRAM bool
+
+// ErrNoRAMDir is returned when RequireRAM is set and there is no RAM-backed
+// directory for temporary files.
+var ErrNoRAMDir = errors.New("no RAM-backed directory for temporary files; unset require_ram_temp to use the disk")
+
+// tempDir is a place temporary files may go.
+type tempDir struct {
+	path string
+	ram  bool // RAM-backed, so files never reach the disk
+}
+
+// tempDirs lists where temporary files may go, best first.
+func tempDirs() []tempDir {
+	var dirs []tempDir
+	suffix := "blue"
+	if uid := os.Getuid(); uid >= 0 {
+		suffix += "-" + strconv.Itoa(uid)
+	}
+	// $XDG_RUNTIME_DIR belongs to the user alone and is usually tmpfs
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" && ramBacked(d) {
+		dirs = append(dirs, tempDir{filepath.Join(d, "blue"), true})
+	}
+	if ramBacked("/dev/shm") {
+		dirs = append(dirs, tempDir{filepath.Join("/dev/shm", suffix), true})
+	}
+	dirs = append(dirs, tempDir{filepath.Join(os.TempDir(), suffix), false})
+	return dirs
+}
+
+// PrivateTempDir returns a directory only the current user can read,
+// creating it if needed. It prefers RAM-backed locations, so that
+// decrypted notes don't reach the disk, and fails with ErrNoRAMDir if
+// there are none and RequireRAM is set.
+func PrivateTempDir() (string, error) {
+	var errs []error
+	for _, d := range tempDirs() {
+		if !d.ram && RequireRAM {
+			break
+		}
+		if err := privateDir(d.path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return d.path, nil
+	}
+	if RequireRAM {
+		errs = append(errs, ErrNoRAMDir)
+	}
+	return "", errors.Join(errs...)
+}
+
+// privateDir makes path a directory with mode 0700, refusing one that
+// someone else created or that is a symlink.
+func privateDir(path string) error {
+	if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	if err := checkOwner(fi); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if fi.Mode().Perm() != 0700 {
+		return os.Chmod(path, 0700)
+	}
+	return nil
+}
+
+// WriteTemp writes data to a new file in PrivateTempDir, named after
+// pattern as in os.CreateTemp, and returns its path. Remove it with
+// RemoveTemp.
+func WriteTemp(pattern, data string) (string, error) {
+	dir, err := PrivateTempDir()
+	if err != nil {
+		return "", err
+	}
+	// the PID lets CleanTemp tell files of running sessions from orphans
+	f, err := os.CreateTemp(dir, strconv.Itoa(os.Getpid())+"-"+pattern)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(data); err != nil {
+		f.Close()
+		RemoveTemp(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		RemoveTemp(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// RemoveTemp overwrites the file at path with zeros before removing it.
+func RemoveTemp(path string) error {
+	if err := scrub(path); err != nil && !os.IsNotExist(err) {
+		os.Remove(path)
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func scrub(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, zeros{}, fi.Size()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// CleanTemp removes the temporary files that sessions which are no longer
+// running left behind, e.g. after a crash, along with the editor swap and
+// backup files next to them.
+func CleanTemp() error {
+	var errs []error
+	for _, d := range tempDirs() {
+		entries, err := os.ReadDir(d.path)
+		if err != nil {
+			continue
+		}
+		fi, err := os.Lstat(d.path)
+		if err != nil || !fi.IsDir() || checkOwner(fi) != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			// "123-note-x.md", or ".123-note-x.md.swp" from vim
+			pid, _, ok := strings.Cut(strings.TrimPrefix(e.Name(), "."), "-")
+			n, err := strconv.Atoi(pid)
+			if !ok || err != nil || ProcessAlive(n) {
+				continue
+			}
+			if err := RemoveTemp(filepath.Join(d.path, e.Name())); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,20 @@
+package utils
+
+import "syscall"
+
+// filesystem magic numbers from statfs(2)
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+// ramBacked reports whether dir is on tmpfs or ramfs.
+func ramBacked(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	// Type is signed, and narrower on some platforms
+	t := uint32(st.Type)
+	return t == tmpfsMagic || t == ramfsMagic
+}
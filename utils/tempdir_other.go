@@ -0,0 +1,9 @@
+//go:build !linux
+
+package utils
+
+// ramBacked reports whether dir is RAM-backed. Outside Linux there is no
+// portable way to tell, so it says no.
+func ramBacked(dir string) bool {
+	return false
+}
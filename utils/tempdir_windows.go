@@ -0,0 +1,9 @@
+package utils
+
+import "os"
+
+// checkOwner does nothing on Windows, where the temp directory is already
+// per user.
+func checkOwner(fi os.FileInfo) error {
+	return nil
+}
@@ -1,38 +1,49 @@
 package utils
 
 import (
+	"errors"
 	"os"
 	"os/exec"
+	"runtime"
+	"strings"
 )
 
+// Editor is the command notes are edited with, e.g. "code --wait". When
+// empty, $EDITOR is used, then nvim or vi.
+var Editor string
+
+// ErrNoEditor is returned by OpenEditorWithContent when no editor is
+// configured or installed.
+var ErrNoEditor = errors.New("no editor found; set $EDITOR or editor in the config")
+
+// OpenEditorWithContent edits initial in the external editor and returns
+// the result. The text goes through a file in PrivateTempDir, which is
+// overwritten and removed afterwards.
 func OpenEditorWithContent(initial string) (string, error) {
-	ed := os.Getenv("EDITOR")
+	ed := Editor
+	if ed == "" {
+		ed = os.Getenv("EDITOR")
+	}
 	if ed == "" {
 		if p, err := exec.LookPath("nvim"); err == nil {
 			ed = p
 		} else if p, err := exec.LookPath("vi"); err == nil {
 			ed = p
+		} else if runtime.GOOS == "windows" {
+			ed = "notepad"
 		} else {
-			ed = "notepad" // Windows fallback
+			return "", ErrNoEditor
 		}
 	}
 
-	tmp, err := os.CreateTemp("", "blue-note-*.md")
+	tmpName, err := WriteTemp("note-*.md", initial)
 	if err != nil {
 		return "", err
 	}
-	tmpName := tmp.Name()
-	defer func() {
-		_ = tmp.Close()
-		_ = os.Remove(tmpName)
-	}()
-
-	if _, err := tmp.WriteString(initial); err != nil {
-		return "", err
-	}
-	_ = tmp.Sync()
+	defer RemoveTemp(tmpName)
 
-	cmd := exec.Command(ed, tmpName)
+	args := strings.Fields(ed)
+	cmd := exec.Command(args[0], append(args[1:], tmpName)...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr